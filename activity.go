@@ -0,0 +1,87 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// activitySuffix is appended to the store's prefix to form the key of the
+// sorted set of last activity times, see SetTrackLastActivity. Generated IDs
+// never contain "_", so it cannot collide with a session key.
+const activitySuffix = "_activity"
+
+// SetTrackLastActivity makes loading a session, with New, Get or
+// LoadAndTouch, and extending it with SetTTL record the current time as the
+// session's last activity, read back with LastActivity, e.g. for "last seen"
+// displays that should not rewrite the session on every request.
+//
+// Times are kept in one sorted set per store prefix, scored by unix time in
+// milliseconds, so each load costs an extra ZADD round-trip, and the set is a
+// single hot key on Redis Cluster. Entries older than the store's
+// Options.MaxAge or DefaultMaxAge, whichever is longer, are trimmed on each
+// write. Deleting a session removes its entry in the same MULTI/EXEC, so a
+// failed delete leaves both; behind a proxy that rejects cross-slot
+// transactions, tracking cannot be combined with SetHashTag. Disabling
+// tracking stops the writes but keeps the set until it is trimmed or flushed.
+// Default: false.
+func (s *GoRediStore) SetTrackLastActivity(b bool) {
+	s.trackActivity = b
+}
+
+// LastActivity returns the last time the session with the given ID was
+// loaded or extended while SetTrackLastActivity was enabled. It returns
+// ErrSessionNotFound if no activity is recorded for the ID.
+func (s *GoRediStore) LastActivity(ctx context.Context, id string) (time.Time, error) {
+	ms, err := s.Client.ZScore(ctx, s.activityKey(), s.keyID(id)).Result()
+	if err == redis.Nil {
+		return time.Time{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)), nil
+}
+
+// activityKey returns the key of the store's sorted set of activity times.
+func (s *GoRediStore) activityKey() string {
+	return s.prefix() + activitySuffix
+}
+
+// recordActivity stores the current time as the last activity of the session
+// with the given ID, if SetTrackLastActivity is enabled, and trims entries
+// older than the longest session age.
+func (s *GoRediStore) recordActivity(ctx context.Context, id string) error {
+	if !s.trackActivity {
+		return nil
+	}
+	age := s.Options.MaxAge
+	if s.DefaultMaxAge > age {
+		age = s.DefaultMaxAge
+	}
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(age)*time.Second).UnixNano() / int64(time.Millisecond)
+	key := s.activityKey()
+	_, err := s.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano() / int64(time.Millisecond)), Member: s.keyID(id)})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+		return nil
+	})
+	return err
+}
+
+// forgetActivity removes the activity entry of the session with the given ID.
+func (s *GoRediStore) forgetActivity(ctx context.Context, id string) error {
+	if !s.trackActivity {
+		return nil
+	}
+	return s.Client.ZRem(ctx, s.activityKey(), s.keyID(id)).Err()
+}
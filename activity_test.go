@@ -0,0 +1,130 @@
+package goredistore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLastActivity(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix("activity_")
+	defer store.Flush(ctx)
+	store.SetTrackLastActivity(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if _, err = store.LastActivity(ctx, session.ID); err != ErrSessionNotFound {
+		t.Errorf("Expected no activity before the first load; Got %v", err)
+	}
+
+	load := func() time.Time {
+		loaded := sessions.NewSession(store, "session-key")
+		loaded.ID = session.ID
+		if _, err := store.load(ctx, loaded); err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		at, err := store.LastActivity(ctx, session.ID)
+		if err != nil {
+			t.Fatalf("Error reading last activity: %v", err)
+		}
+		return at
+	}
+	first := load()
+	if d := time.Since(first); d < 0 || d > time.Second {
+		t.Errorf("Expected the activity to be recent; Got %v", first)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if second := load(); !second.After(first) {
+		t.Errorf("Expected the activity to move forward; Got %v then %v", first, second)
+	}
+	if n, _ := store.Count(ctx); n != 1 {
+		t.Errorf("Expected the activity set not to be counted as a session; Got %d", n)
+	}
+
+	session.Options.MaxAge = -1
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if _, err = store.LastActivity(ctx, session.ID); err != ErrSessionNotFound {
+		t.Errorf("Expected the activity to be removed with the session; Got %v", err)
+	}
+}
+
+// failingPipelineHook fails pipelines and transactions while enabled,
+// before any of their commands reach the server.
+type failingPipelineHook struct{ enabled int32 }
+
+func (h *failingPipelineHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *failingPipelineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h *failingPipelineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if atomic.LoadInt32(&h.enabled) == 1 {
+			return errors.New("connection reset by peer")
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestDeleteWithActivityIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix("activity_tx_")
+	defer store.Flush(ctx)
+	store.SetTrackLastActivity(true)
+	hook := &failingPipelineHook{}
+	client.AddHook(hook)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if err = store.SetTTL(ctx, session.ID, time.Minute); err != nil {
+		t.Fatalf("Error recording activity: %v", err)
+	}
+
+	atomic.StoreInt32(&hook.enabled, 1)
+	session.Options.MaxAge = -1
+	if err = session.Save(req, NewRecorder()); err == nil {
+		t.Fatal("Expected the delete to fail")
+	}
+	atomic.StoreInt32(&hook.enabled, 0)
+	if ok, _ := store.Exists(ctx, session.ID); !ok {
+		t.Error("Expected the session to survive a failed delete")
+	}
+	if _, err = store.LastActivity(ctx, session.ID); err != nil {
+		t.Errorf("Expected the activity entry to survive a failed delete; Got %v", err)
+	}
+
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if ok, _ := store.Exists(ctx, session.ID); ok {
+		t.Error("Expected the session to be deleted")
+	}
+	if _, err = store.LastActivity(ctx, session.ID); err != ErrSessionNotFound {
+		t.Errorf("Expected the activity entry to be deleted; Got %v", err)
+	}
+}
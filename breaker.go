@@ -0,0 +1,99 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting redis while the store's
+// circuit breaker is open.
+var ErrCircuitOpen = newError(CodeBackend, "goredistore: circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // requests pass, failures are counted
+	breakerOpen                         // requests fail fast until the cooldown ends
+	breakerHalfOpen                     // a single probe request is in flight
+)
+
+// circuitBreaker stops requests to redis after consecutive failures.
+//
+// After `failures` consecutive backend failures it opens and rejects requests
+// with ErrCircuitOpen for `cooldown`. The first request after the cooldown is
+// let through as a probe: success closes the breaker, failure opens it again.
+type circuitBreaker struct {
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	state       breakerState
+	consecutive int
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(failures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{failures: failures, cooldown: cooldown, now: time.Now}
+}
+
+// allow returns ErrCircuitOpen if a request may not be sent to redis.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of an allowed request.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isBackendFailure(err) {
+		b.state = breakerClosed
+		b.consecutive = 0
+		return
+	}
+	b.consecutive++
+	if b.state == breakerHalfOpen || b.consecutive >= b.failures {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// isBackendFailure reports whether err means redis could not be reached, as
+// opposed to success, a miss or an error reply from a healthy server.
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || isPoolTimeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isPoolTimeout reports whether err is go-redis's pool timeout error, which
+// is not exported.
+func isPoolTimeout(err error) bool {
+	return err.Error() == "redis: connection pool timeout"
+}
@@ -0,0 +1,82 @@
+package goredistore
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// netFailure is a net.Error standing in for an unreachable server.
+var netFailure error = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// Closed: failures below the threshold and reply errors pass.
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("Expected closed breaker to allow; Got %v", err)
+		}
+		b.record(netFailure)
+	}
+	b.record(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	if b.state != breakerClosed {
+		t.Fatal("Expected a reply error to reset the breaker")
+	}
+	for i := 0; i < 3; i++ {
+		b.allow()
+		b.record(netFailure)
+	}
+
+	// Open: fail fast until the cooldown ends.
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("Expected ErrCircuitOpen; Got %v", err)
+	}
+	now = now.Add(time.Minute)
+
+	// Half-open: one probe, others rejected; a failed probe reopens.
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a probe to be allowed; Got %v", err)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("Expected requests during the probe to be rejected; Got %v", err)
+	}
+	b.record(netFailure)
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("Expected failed probe to reopen; Got %v", err)
+	}
+
+	// A successful probe closes it again.
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a probe to be allowed; Got %v", err)
+	}
+	b.record(nil)
+	for i := 0; i < 3; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("Expected closed breaker to allow; Got %v", err)
+		}
+	}
+}
+
+func TestStoreCircuitBreaker(t *testing.T) {
+	store, _ := NewGoRediStore(10, "tcp", ":6378", "", []byte("session-key"))
+	defer store.Close()
+	store.SetCircuitBreaker(2, time.Hour)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	for i := 0; i < 2; i++ {
+		if err := session.Save(req, NewRecorder()); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("Expected a connection error; Got %v", err)
+		}
+	}
+	if err := session.Save(req, NewRecorder()); err != ErrCircuitOpen {
+		t.Errorf("Expected ErrCircuitOpen; Got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"sync"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+// dbClients holds one client per redis database other than Client's, created
+// on first use with Client's options.
+type dbClients struct {
+	mu      sync.Mutex
+	clients map[int]*redis.Client
+	closed  bool
+}
+
+// SetDBSelector routes each session's save, load and delete to the redis
+// database fn returns for it, e.g. to shard sessions by user segment on one
+// server. Since a go-redis client is bound to a single database, the store
+// lazily opens a client, with its own connection pool, for each database
+// other than Client's, so every database in use adds up to PoolSize
+// connections. Close closes them.
+//
+// fn is called before a session is loaded, when only its name and ID are
+// known, so it must choose from those. Methods that take an ID, such as TTL,
+// and SCAN-based helpers such as Flush operate on Client's database only, and
+// sessions routed elsewhere bypass write-behind. Pass nil to store every
+// session through Client.
+func (s *GoRediStore) SetDBSelector(fn func(*sessions.Session) int) {
+	s.dbSelector = fn
+	if fn != nil && s.dbClients == nil {
+		s.dbClients = &dbClients{clients: make(map[int]*redis.Client)}
+	}
+}
+
+// client returns the client for the database the session is stored in.
+func (s *GoRediStore) client(session *sessions.Session) *redis.Client {
+	if s.dbSelector == nil {
+		return s.Client
+	}
+	db := s.dbSelector(session)
+	options := s.Client.Options()
+	if db == options.DB {
+		return s.Client
+	}
+	s.dbClients.mu.Lock()
+	defer s.dbClients.mu.Unlock()
+	c, ok := s.dbClients.clients[db]
+	if !ok {
+		o := *options
+		o.DB = db
+		c = redis.NewClient(&o)
+		c.AddHook(closedHook{})
+		if s.dbClients.closed {
+			c.Close() // fail like the others after Close
+		}
+		s.dbClients.clients[db] = c
+	}
+	return c
+}
+
+// close closes the clients opened for other databases.
+func (d *dbClients) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	var err error
+	for _, c := range d.clients {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDBSelector(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetDBSelector(func(session *sessions.Session) int {
+		if strings.HasPrefix(session.Name(), "cart") {
+			return 1
+		}
+		return 0
+	})
+	db1 := redis.NewClient(&redis.Options{Addr: setup(), DB: 1})
+	defer db1.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	auth, _ := store.New(req, "auth")
+	cart, _ := store.New(req, "cart")
+	for _, session := range []*sessions.Session{auth, cart} {
+		session.Values["name"] = session.Name()
+		if err = session.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	defer store.Client.Del(ctx, store.key(auth.ID))
+	defer db1.Del(ctx, store.key(cart.ID))
+
+	if n, _ := store.Client.Exists(ctx, store.key(auth.ID), store.key(cart.ID)).Result(); n != 1 {
+		t.Errorf("Expected only the auth session in DB 0; Got %d keys", n)
+	}
+	if n, _ := db1.Exists(ctx, store.key(auth.ID), store.key(cart.ID)).Result(); n != 1 {
+		t.Errorf("Expected only the cart session in DB 1; Got %d keys", n)
+	}
+
+	// Loading routes to the same database.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	for _, c := range (&http.Response{Header: rsp.Header()}).Cookies() {
+		req.AddCookie(c)
+	}
+	loaded, err := store.New(req, "cart")
+	if err != nil || loaded.IsNew || loaded.Values["name"] != "cart" {
+		t.Errorf("Expected the cart session from DB 1; Got %v, %v", loaded.Values, err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Code classifies the errors returned by the store.
+type Code int
+
+const (
+	CodeUnknown  Code = iota // not classified, e.g. a context error
+	CodeNotFound             // the session does not exist
+	CodeTooBig               // the session exceeds a size limit
+	CodeCorrupt              // stored data could not be decoded
+	CodeBackend              // redis could not be reached or had no free connection
+	CodeConflict             // a concurrent write won, e.g. merge ran out of retries
+	CodeInvalid              // the call or the store's configuration is invalid
+	CodeExpired              // the session outlived its absolute timeout
+)
+
+var codeNames = [...]string{"unknown", "not found", "too big", "corrupt", "backend", "conflict", "invalid", "expired"}
+
+func (c Code) String() string {
+	if c < 0 || int(c) >= len(codeNames) {
+		return codeNames[CodeUnknown]
+	}
+	return codeNames[c]
+}
+
+// Error is an error with a Code. The store's sentinel errors, such as
+// ErrSessionNotFound, are *Error values, so both errors.Is against a sentinel
+// and ErrorCode work on what the store returns.
+type Error struct {
+	Code Code
+	Err  error // underlying error; nil for sentinels
+	msg  string
+}
+
+// NewError returns an error with the given code wrapping err. Its message is
+// err's.
+func NewError(code Code, err error) *Error {
+	return &Error{Code: code, Err: err}
+}
+
+// newError returns a sentinel error with the given code and message.
+func newError(code Code, msg string) error {
+	return &Error{Code: code, msg: msg}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code of err: that of the first *Error in its chain,
+// CodeBackend for network and pool errors from go-redis, CodeConflict for a
+// failed transaction, and CodeUnknown otherwise, including for nil.
+func ErrorCode(err error) Code {
+	var e *Error
+	switch {
+	case err == nil:
+		return CodeUnknown
+	case errors.As(err, &e):
+		return e.Code
+	case isBackendFailure(err):
+		return CodeBackend
+	case errors.Is(err, redis.TxFailedErr):
+		return CodeConflict
+	}
+	return CodeUnknown
+}
+
+// closedHook makes commands on a closed client fail with an error wrapping
+// both ErrStoreClosed and go-redis's redis.ErrClosed.
+type closedHook struct{}
+
+func (closedHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (closedHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if isClosed(err) {
+			err = fmt.Errorf("%w: %w", ErrStoreClosed, err)
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func (closedHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			if isClosed(cmd.Err()) {
+				cmd.SetErr(fmt.Errorf("%w: %w", ErrStoreClosed, cmd.Err()))
+			}
+		}
+		if isClosed(err) {
+			err = fmt.Errorf("%w: %w", ErrStoreClosed, err)
+		}
+		return err
+	}
+}
+
+// isClosed reports whether err is go-redis's closed client error that has
+// not been wrapped yet.
+func isClosed(err error) bool {
+	return errors.Is(err, redis.ErrClosed) && !errors.Is(err, ErrStoreClosed)
+}
@@ -0,0 +1,100 @@
+package goredistore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestErrorCodes(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	check := func(what string, err error, want Code) {
+		t.Helper()
+		if got := ErrorCode(err); got != want {
+			t.Errorf("Expected code %v for %s; Got %v (%v)", want, what, got, err)
+		}
+	}
+
+	_, err = store.TTL(ctx, "missing")
+	check("a missing session", err, CodeNotFound)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected errors.Is(err, ErrSessionNotFound); Got %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["big"] = strings.Repeat("x", 8192)
+	check("an oversized session", session.Save(req, NewRecorder()), CodeTooBig)
+
+	session.Values = map[interface{}]interface{}{}
+	session.Options.MaxAge = -1
+	_, err = store.SaveIfAbsent(ctx, session)
+	check("an invalid TTL", err, CodeInvalid)
+
+	store.Client.Set(ctx, store.key("corrupt"), "not gob", time.Minute)
+	defer store.Client.Del(ctx, store.key("corrupt"))
+	corrupt := sessions.NewSession(store, "session-key")
+	corrupt.ID = "corrupt"
+	_, err = store.load(ctx, corrupt)
+	check("undecodable data", err, CodeCorrupt)
+
+	_, err = store.Import(ctx, strings.NewReader("garbage"))
+	check("a malformed export", err, CodeCorrupt)
+
+	down, _ := NewGoRediStoreWithPool(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}), []byte("session-key"))
+	defer down.Close()
+	_, err = down.TTL(ctx, "any")
+	check("an unreachable server", err, CodeBackend)
+	check("an open circuit", ErrCircuitOpen, CodeBackend)
+	check("an exhausted pool", fmt.Errorf("%w: redis: connection pool timeout", ErrPoolExhausted), CodeBackend)
+
+	check("a failed transaction", redis.TxFailedErr, CodeConflict)
+	check("an expired session", ErrSessionExpired, CodeExpired)
+	check("a context error", context.Canceled, CodeUnknown)
+}
+
+func TestErrStoreClosed(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.Close(); err != nil {
+		t.Fatalf("Error closing store: %v", err)
+	}
+
+	check := func(what string, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrStoreClosed) || !errors.Is(err, redis.ErrClosed) {
+			t.Errorf("Expected ErrStoreClosed from %s; Got %v", what, err)
+		}
+	}
+	check("Save", session.Save(req, NewRecorder()))
+	session.ID = "closed"
+	_, err = store.load(ctx, session)
+	check("load", err)
+	_, err = store.TTL(ctx, "closed")
+	check("TTL", err)
+	_, err = store.Export(ctx, new(strings.Builder))
+	check("Export", err)
+	_, err = store.Flush(ctx)
+	check("Flush", err)
+	if ErrorCode(ErrStoreClosed) != CodeBackend {
+		t.Errorf("Expected CodeBackend for ErrStoreClosed")
+	}
+}
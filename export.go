@@ -0,0 +1,151 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// exportMagic starts every export, identifying the format and its version.
+const exportMagic = "GRSEXP1\n"
+
+// maxExportValue bounds the lengths Import accepts, the largest value redis
+// can store.
+const maxExportValue = 512 << 20
+
+// ErrBadExport is returned by Import for data not written by Export.
+var ErrBadExport = newError(CodeCorrupt, "goredistore: malformed session export")
+
+// Export writes every session under the store's prefix to w, for backup or
+// migration, and returns the number written. Each record holds the session
+// ID (its hash, with SetKeyHashing), its remaining TTL in milliseconds (-1
+// for none) and its raw stored value, each length-prefixed. Companion keys
+// such as creation times are not exported.
+//
+// Export walks the keyspace with SCAN and is not a point-in-time snapshot:
+// sessions saved while it runs may or may not be included.
+func (s *GoRediStore) Export(ctx context.Context, w io.Writer) (int, error) {
+	c := s.Client
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(exportMagic); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := s.scan(ctx, func(keys []string) error {
+		gets := make([]*redis.StringCmd, len(keys))
+		pttls := make([]*redis.Cmd, len(keys))
+		if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				gets[i] = pipe.Get(ctx, key)
+				pttls[i] = pipe.Do(ctx, "PTTL", key)
+			}
+			return nil
+		}); err != nil && err != redis.Nil {
+			return err
+		}
+		for i, key := range keys {
+			data, err := gets[i].Bytes()
+			if err == redis.Nil {
+				continue // expired since the scan
+			}
+			if err != nil {
+				return err
+			}
+			ttl, err := pttls[i].Int64()
+			if err != nil {
+				return err
+			}
+			if ttl == -2 {
+				continue
+			}
+			writeExportRecord(bw, key[len(s.prefix()):], ttl, data)
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// writeExportRecord appends one record to w. Write errors are reported by
+// w.Flush.
+func writeExportRecord(w *bufio.Writer, id string, ttl int64, data []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(id)))])
+	w.WriteString(id)
+	w.Write(buf[:binary.PutVarint(buf[:], ttl)])
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+	w.Write(data)
+}
+
+// Import restores sessions written by Export into this store, under its own
+// prefix, and returns the number restored. Each session gets the TTL it had
+// left when it was exported; existing sessions with the same ID are
+// overwritten. It returns ErrBadExport if r does not hold an export.
+func (s *GoRediStore) Import(ctx context.Context, r io.Reader) (int, error) {
+	c := s.Client
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != exportMagic {
+		return 0, ErrBadExport
+	}
+	n := 0
+	for {
+		id, err := readExportBytes(br)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		ttl, err := binary.ReadVarint(br)
+		if err != nil {
+			return n, ErrBadExport
+		}
+		data, err := readExportBytes(br)
+		if err == io.EOF {
+			err = ErrBadExport
+		}
+		if err != nil {
+			return n, err
+		}
+		var expiration time.Duration // -1: no expiry
+		if ttl > 0 {
+			expiration = time.Duration(ttl) * time.Millisecond
+		}
+		// id is the exported key suffix, already hashed if the source was.
+		if err = c.Set(ctx, s.prefix()+string(id), data, expiration).Err(); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// readExportBytes reads one length-prefixed field. It returns io.EOF only if
+// r is exhausted before the field starts.
+func readExportBytes(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil || l > maxExportValue {
+		return nil, ErrBadExport
+	}
+	b := make([]byte, l)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, ErrBadExport
+	}
+	return b, nil
+}
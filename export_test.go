@@ -0,0 +1,70 @@
+package goredistore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix(fmt.Sprintf("export_%d_", time.Now().UnixNano()))
+	defer store.Flush(context.Background())
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	ids := map[string]time.Duration{}
+	for i, ttl := range []time.Duration{time.Minute, time.Hour} {
+		session, _ := store.New(req, "session-key")
+		session.Values["i"] = i
+		if err = store.SaveWithTTL(req, NewRecorder(), session, ttl); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		ids[session.ID] = ttl
+	}
+	store.Client.Set(ctx, store.key("persistent"), "raw", 0)
+	ids["persistent"] = NoExpiry
+
+	var buf bytes.Buffer
+	if n, err := store.Export(context.Background(), &buf); err != nil || n != 3 {
+		t.Fatalf("Expected 3 exported sessions; Got %d, %v", n, err)
+	}
+
+	// Import into a fresh namespace.
+	target := store.Derive(fmt.Sprintf("import_%d_", time.Now().UnixNano()))
+	defer target.Flush(context.Background())
+	if n, err := target.Import(context.Background(), bytes.NewReader(buf.Bytes())); err != nil || n != 3 {
+		t.Fatalf("Expected 3 imported sessions; Got %d, %v", n, err)
+	}
+	for id, want := range ids {
+		orig, _ := store.Client.Get(ctx, store.key(id)).Result()
+		got, err := target.Client.Get(ctx, target.key(id)).Result()
+		if err != nil || got != orig {
+			t.Errorf("Expected %s to round-trip; Got %q, %v", id, got, err)
+		}
+		ttl, _ := target.TTL(context.Background(), id)
+		if want == NoExpiry {
+			if ttl != NoExpiry {
+				t.Errorf("Expected %s to have no expiry; Got %v", id, ttl)
+			}
+		} else if ttl <= want-time.Second || ttl > want {
+			t.Errorf("Expected %s TTL near %v; Got %v", id, want, ttl)
+		}
+	}
+
+	if _, err = target.Import(context.Background(), strings.NewReader("not an export")); err != ErrBadExport {
+		t.Errorf("Expected ErrBadExport; Got %v", err)
+	}
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if _, err = target.Import(context.Background(), bytes.NewReader(truncated)); err != ErrBadExport {
+		t.Errorf("Expected ErrBadExport for a truncated export; Got %v", err)
+	}
+}
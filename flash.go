@@ -0,0 +1,83 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+// flashesKey is the key under which gorilla/sessions stores flashes added
+// without a custom key.
+const flashesKey = "_flash"
+
+// flashTTL caps how long separately stored flashes wait to be read.
+const flashTTL = 5 * time.Minute
+
+// SetSeparateFlashStorage makes Save store the session's flashes, those added
+// with AddFlash without a custom key, in a companion key instead of the
+// session value, so they do not count toward SetMaxLength and live at most
+// five minutes, or the session's TTL if shorter. Loading the session moves
+// them back into its values and deletes the companion key, so flashes are
+// read once: they reach Flashes on the next load only, whether or not that
+// request saves the session again. Flashes with a custom key, and sessions
+// written with SaveMerge or read with LoadAndTouch, keep flashes in the
+// session value.
+// Default: false.
+func (s *GoRediStore) SetSeparateFlashStorage(b bool) {
+	s.separateFlash = b
+}
+
+// takeFlashes removes the flashes from the session's values while it is
+// saved, and returns them with a func that puts them back.
+func (s *GoRediStore) takeFlashes(session *sessions.Session) (flashes []interface{}, restore func()) {
+	if s.separateFlash {
+		if flashes, _ = session.Values[flashesKey].([]interface{}); len(flashes) > 0 {
+			delete(session.Values, flashesKey)
+			return flashes, func() { session.Values[flashesKey] = flashes }
+		}
+	}
+	return nil, func() {}
+}
+
+// saveFlashes writes flashes to the session's flash companion key.
+func (s *GoRediStore) saveFlashes(ctx context.Context, c *redis.Client, session *sessions.Session, flashes []interface{}, ttl time.Duration) error {
+	holder := sessions.NewSession(nil, session.Name())
+	holder.Values[flashesKey] = flashes
+	b, err := s.serializer.Serialize(holder)
+	if err != nil {
+		return err
+	}
+	if ttl > flashTTL {
+		ttl = flashTTL
+	}
+	return c.Set(ctx, s.sessionKey(session)+flashSuffix, b, ttl).Err()
+}
+
+// loadFlashes moves the flashes stored for the session, if any, into its
+// values, deleting them from redis.
+func (s *GoRediStore) loadFlashes(ctx context.Context, c *redis.Client, session *sessions.Session) error {
+	data, err := c.GetDel(ctx, s.sessionKey(session)+flashSuffix).Bytes()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	holder := sessions.NewSession(nil, session.Name())
+	if err = s.serializer.Deserialize(data, holder); err != nil {
+		return NewError(CodeCorrupt, err)
+	}
+	if flashes, ok := holder.Values[flashesKey].([]interface{}); ok {
+		stored, _ := session.Values[flashesKey].([]interface{})
+		session.Values[flashesKey] = append(stored, flashes...)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSeparateFlashStorage(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSeparateFlashStorage(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	session.AddFlash("saved")
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key, key+flashSuffix)
+	if len(session.Flashes()) != 1 {
+		t.Error("Expected Save to keep the flashes in the session")
+	}
+
+	raw, _ := store.RawValue(ctx, session.ID)
+	stored := sessions.NewSession(store, "session-key")
+	if err = store.deserialize(raw, stored); err != nil {
+		t.Fatalf("Error deserializing session: %v", err)
+	}
+	if _, ok := stored.Values[flashesKey]; ok {
+		t.Error("Expected the flashes not to be stored in the session value")
+	}
+	if ttl, _ := store.Client.TTL(ctx, key+flashSuffix).Result(); ttl <= 0 || ttl > flashTTL {
+		t.Errorf("Expected a flash TTL of at most %v; Got %v", flashTTL, ttl)
+	}
+
+	cookie := rsp.HeaderMap["Set-Cookie"][0]
+	load := func() []interface{} {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		loaded, err := store.New(req, "session-key")
+		if err != nil || loaded.IsNew {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		if loaded.Values["user"] != "alice" {
+			t.Errorf("Expected the session values; Got %v", loaded.Values)
+		}
+		return loaded.Flashes()
+	}
+	if flashes := load(); len(flashes) != 1 || flashes[0] != "saved" {
+		t.Errorf("Expected the flash on the first load; Got %v", flashes)
+	}
+	if flashes := load(); len(flashes) != 0 {
+		t.Errorf("Expected no flashes on the next load; Got %v", flashes)
+	}
+	if n, _ := store.Client.Exists(ctx, key+flashSuffix).Result(); n != 0 {
+		t.Error("Expected the flash key to be deleted")
+	}
+}
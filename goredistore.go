@@ -14,34 +14,131 @@ package goredistore
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/base64"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
-	"github.com/go-redis/redis"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Amount of time for cookies/redis keys to expire.
 
 var sessionExpire = 86400 * 30
 
+// Suffixes appended to a session key to form its companion keys, which hold
+// auxiliary data sharing the session's lifetime.
+const (
+	createdAtSuffix   = ":created" // creation time, see SetTrackCreatedAt
+	fingerprintSuffix = ":fp"      // client fingerprint hash, see SetFingerprint
+	flashSuffix       = ":flash"   // flashes, see SetSeparateFlashStorage
+)
+
+// companionSuffixes lists every companion key suffix. createdAtSuffix must
+// stay first: loadAndTouchScript reads the creation time as KEYS[2].
+var companionSuffixes = []string{createdAtSuffix, fingerprintSuffix, flashSuffix}
+
+var (
+	// ErrSessionNotFound is returned when no data is stored for a session ID.
+	ErrSessionNotFound = newError(CodeNotFound, "goredistore: session not found")
+	// ErrSessionTooBig is returned when a session value exceeds the store's
+	// maxLength on save, or its maxLoadSize on load.
+	ErrSessionTooBig = newError(CodeTooBig, "SessionStore: the value to store is too big")
+	// ErrInvalidTTL is returned when a session's TTL is not positive or is
+	// larger than math.MaxInt32 seconds.
+	ErrInvalidTTL = newError(CodeInvalid, "goredistore: invalid session TTL")
+	// ErrNoKeyPrefix is returned by Flush for a store without a key prefix,
+	// whose namespace would be the whole database.
+	ErrNoKeyPrefix = newError(CodeInvalid, "goredistore: store has no key prefix")
+	// ErrSessionExpired is returned when saving a session that has outlived
+	// the store's absolute timeout.
+	ErrSessionExpired = newError(CodeExpired, "goredistore: session exceeded its absolute timeout")
+	// ErrPoolExhausted wraps go-redis's pool timeout error, returned when no
+	// connection became free within the client's PoolTimeout, so capacity
+	// problems can be told apart from network ones.
+	ErrPoolExhausted = newError(CodeBackend, "goredistore: connection pool exhausted")
+	// ErrStoreClosed is returned by operations on a store whose client has
+	// been closed, e.g. by Close during shutdown. It also matches
+	// redis.ErrClosed with errors.Is.
+	ErrStoreClosed = newError(CodeBackend, "goredistore: store is closed")
+	// ErrCookieTooLarge is returned on save when the session's encoded cookie
+	// is longer than the store's maxCookieLength, which browsers would drop.
+	ErrCookieTooLarge = newError(CodeTooBig, "goredistore: encoded cookie is too large")
+	// ErrCookieNameFunc is returned by RefreshCookie on a store with a
+	// SetCookieNameFunc, whose cookie names cannot be derived without the
+	// request.
+	ErrCookieNameFunc = newError(CodeInvalid, "goredistore: cookie name needs the request")
+	// ErrInvalidInterval is returned by StartHealthChecks and
+	// EnableWriteBehind for an interval that is not positive.
+	ErrInvalidInterval = newError(CodeInvalid, "goredistore: interval must be positive")
+)
+
 // SessionSerializer provides an interface hook for alternative serializers
 type SessionSerializer interface {
 	Deserialize(d []byte, ss *sessions.Session) error
 	Serialize(ss *sessions.Session) ([]byte, error)
 }
 
+// StreamSerializer is a SessionSerializer that can also encode to and decode
+// from a stream. save and load use the streaming methods when a serializer
+// implements them: the encoded session is never buffered beyond the store's
+// maxLength, so an oversized session fails without being held in memory.
+type StreamSerializer interface {
+	SessionSerializer
+	SerializeTo(w io.Writer, ss *sessions.Session) error
+	DeserializeFrom(r io.Reader, ss *sessions.Session) error
+}
+
 // JSONSerializer encode the session map to JSON.
+//
+// Values decode as the types encoding/json produces (string, float64, bool,
+// nil, []interface{} and map[string]interface{}), with two exceptions:
+// time.Time and []byte values, at the top level or nested in slices and maps,
+// are stored with a type hint and decode back to time.Time and []byte. The
+// hint is a map with the single key "$time" or "$bytes"; keys of nested maps
+// that could be mistaken for one are escaped with an extra "$", so such maps
+// round-trip unchanged.
+//
+// Output is deterministic: encoding/json writes map keys in sorted order, at
+// every level, so identical Values always serialize to identical bytes.
 type JSONSerializer struct{}
 
-// Serialize to JSON. Will err if there are unmarshalable key values
+// Type hints JSONSerializer uses to round-trip time.Time and []byte values.
+const (
+	jsonTimeHint  = "$time"
+	jsonBytesHint = "$bytes"
+)
+
+// isJSONHintKey reports whether k is a type hint key with one or more
+// leading "$", i.e. a map key that must be escaped.
+func isJSONHintKey(k string) bool {
+	name := strings.TrimLeft(k, "$")
+	return len(name) < len(k) && (name == jsonTimeHint[1:] || name == jsonBytesHint[1:])
+}
+
+// Serialize to JSON. Will err if there are unmarshalable key values, or if a
+// key or string value is not valid UTF-8, which encoding/json would otherwise
+// silently replace with U+FFFD.
 func (s JSONSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
 	m := make(map[string]interface{}, len(ss.Values))
 	for k, v := range ss.Values {
@@ -51,11 +148,123 @@ func (s JSONSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
 			fmt.Printf("goredistore.JSONSerializer.serialize() Error: %v", err)
 			return nil, err
 		}
-		m[ks] = v
+		if err := validUTF8(ks, v); err != nil {
+			return nil, err
+		}
+		m[ks] = jsonEncodeValue(v)
 	}
 	return json.Marshal(m)
 }
 
+// jsonEncodeValue replaces time.Time and []byte values in v with their
+// type-hinted forms.
+func jsonEncodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return map[string]string{jsonTimeHint: v.Format(time.RFC3339Nano)}
+	case []byte:
+		return map[string]string{jsonBytesHint: base64.StdEncoding.EncodeToString(v)}
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = jsonEncodeValue(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if isJSONHintKey(k) {
+				k = "$" + k
+			}
+			out[k] = jsonEncodeValue(e)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for k, e := range v {
+			if isJSONHintKey(k) {
+				k = "$" + k
+			}
+			out[k] = e
+		}
+		return out
+	}
+	return v
+}
+
+// jsonDecodeValue turns the type-hinted forms in v back into time.Time and
+// []byte values, and unescapes the map keys jsonEncodeValue escaped.
+func jsonDecodeValue(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case []interface{}:
+		for i, e := range v {
+			if v[i], err = jsonDecodeValue(e); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if t, ok := v[jsonTimeHint].(string); ok {
+				return time.Parse(time.RFC3339Nano, t)
+			}
+			if b, ok := v[jsonBytesHint].(string); ok {
+				return base64.StdEncoding.DecodeString(b)
+			}
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if strings.HasPrefix(k, "$") && isJSONHintKey(k[1:]) {
+				k = k[1:]
+			}
+			if out[k], err = jsonDecodeValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// validUTF8 checks the key and any strings nested in v, descending into the
+// slice and map types encoding/json produces, for invalid UTF-8.
+func validUTF8(key string, v interface{}) error {
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("Invalid UTF-8 in key %q, cannot serialize session to JSON", key)
+	}
+	switch v := v.(type) {
+	case string:
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("Invalid UTF-8 in value of key %q, cannot serialize session to JSON", key)
+		}
+	case []string:
+		for _, e := range v {
+			if err := validUTF8(key, e); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if err := validUTF8(key, e); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		for k, e := range v {
+			if err := validUTF8(key+"."+k, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			if err := validUTF8(key+"."+k, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Deserialize back to map[string]interface{}
 func (s JSONSerializer) Deserialize(d []byte, ss *sessions.Session) error {
 	m := make(map[string]interface{})
@@ -65,42 +274,233 @@ func (s JSONSerializer) Deserialize(d []byte, ss *sessions.Session) error {
 		return err
 	}
 	for k, v := range m {
+		if v, err = jsonDecodeValue(v); err != nil {
+			return err
+		}
 		ss.Values[k] = v
 	}
 	return nil
 }
 
 // GobSerializer uses gob package to encode the session map
+//
+// Output is not deterministic: gob encodes maps in iteration order, so saves
+// of identical Values may differ byte-for-byte. Use JSONSerializer where
+// stored values are compared or content-addressed.
 type GobSerializer struct{}
 
-// Serialize using gob
-func (s GobSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+// Serialize using gob. A panic while encoding, e.g. from a value's
+// GobEncode method, is returned as an error.
+func (s GobSerializer) Serialize(ss *sessions.Session) (_ []byte, err error) {
+	defer recoverGob("encode", &err)
 	buf := new(bytes.Buffer)
 	enc := gob.NewEncoder(buf)
-	err := enc.Encode(ss.Values)
+	err = enc.Encode(ss.Values)
 	if err == nil {
 		return buf.Bytes(), nil
 	}
 	return nil, err
 }
 
-// Deserialize back to map[interface{}]interface{}
-func (s GobSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+// Deserialize back to map[interface{}]interface{}. A panic while decoding is
+// returned as an error.
+func (s GobSerializer) Deserialize(d []byte, ss *sessions.Session) (err error) {
+	defer recoverGob("decode", &err)
 	dec := gob.NewDecoder(bytes.NewBuffer(d))
 	return dec.Decode(&ss.Values)
 }
 
+// recoverGob turns a panic during a gob operation into an error in *err, so
+// an unsupported session value fails the request instead of crashing the
+// handler. Stack overflows from cyclic values cannot be recovered.
+func recoverGob(op string, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("goredistore: gob %s panicked: %v", op, r)
+	}
+}
+
+// MultiSerializer writes sessions with one of several serializers, tagging
+// the data with the format's name, and reads each session with the
+// serializer its tag names. Untagged data is read with GobSerializer, so a
+// store can switch e.g. from Gob to JSON in place: legacy sessions still
+// load, and are rewritten in the new format on their next save.
+//
+//	store.SetSerializer(MultiSerializer{Preferred: "json"})
+type MultiSerializer struct {
+	Preferred string                       // name of the format Serialize writes
+	Formats   map[string]SessionSerializer // nil means "json" and "gob"
+}
+
+// multiSerializerMarker starts tagged data, followed by the format name and
+// another marker. Gob streams start with a non-zero length and JSON objects
+// with '{', so neither is mistaken for tagged data.
+const multiSerializerMarker = 0
+
+// defaultFormats are the formats of a MultiSerializer without Formats.
+var defaultFormats = map[string]SessionSerializer{
+	"json": JSONSerializer{},
+	"gob":  GobSerializer{},
+}
+
+func (s MultiSerializer) formats() map[string]SessionSerializer {
+	if s.Formats == nil {
+		return defaultFormats
+	}
+	return s.Formats
+}
+
+// Serialize with the preferred format, prefixed with its tag.
+func (s MultiSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	ser, ok := s.formats()[s.Preferred]
+	if !ok {
+		return nil, fmt.Errorf("goredistore: unknown serializer format %q", s.Preferred)
+	}
+	b, err := ser.Serialize(ss)
+	if err != nil {
+		return nil, err
+	}
+	tagged := make([]byte, 0, len(s.Preferred)+2+len(b))
+	tagged = append(tagged, multiSerializerMarker)
+	tagged = append(tagged, s.Preferred...)
+	tagged = append(tagged, multiSerializerMarker)
+	return append(tagged, b...), nil
+}
+
+// isTagged reports whether d was written by MultiSerializer.
+func isTagged(d []byte) bool {
+	return len(d) > 0 && d[0] == multiSerializerMarker
+}
+
+// formatMagic prefixes data that ContextWithFormat saved through a store
+// whose serializer is not a MultiSerializer. Unlike the one-byte tag, it is
+// long enough not to be mistaken for the output of a custom serializer that
+// happens to start with 0x00.
+const formatMagic = "\x00goredistore-format\x00"
+
+// formatKey is the context key for the format set by ContextWithFormat.
+type formatKey struct{}
+
+// ContextWithFormat returns a copy of ctx that makes saves using it, e.g.
+// through a request carrying it, serialize with the named MultiSerializer
+// format ("json" or "gob", unless the store's MultiSerializer defines
+// others) instead of the store's serializer. The data is tagged with the
+// format, so it is read back correctly whatever the store's serializer is.
+//
+//	r = r.WithContext(goredistore.ContextWithFormat(r.Context(), "json"))
+//	err := session.Save(r, w)
+func ContextWithFormat(ctx context.Context, format string) context.Context {
+	return context.WithValue(ctx, formatKey{}, format)
+}
+
+// Deserialize with the format named by the data's tag, or Gob if untagged.
+func (s MultiSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	if !isTagged(d) {
+		return GobSerializer{}.Deserialize(d, ss)
+	}
+	end := bytes.IndexByte(d[1:], multiSerializerMarker)
+	if end < 0 {
+		return errors.New("goredistore: malformed serializer format tag")
+	}
+	name := string(d[1 : 1+end])
+	ser, ok := s.formats()[name]
+	if !ok {
+		return fmt.Errorf("goredistore: unknown serializer format %q", name)
+	}
+	return ser.Deserialize(d[2+end:], ss)
+}
+
 // RediStore stores sessions in a redis backend.
 type GoRediStore struct {
 	Client        *redis.Client
 	Codecs        []securecookie.Codec
 	Options       *sessions.Options // default configuration
 	DefaultMaxAge int               // default Redis TTL for a MaxAge == 0 session
+	nameOptions   map[string]sessions.Options
 	maxLength     int
+	maxLoadSize   int
+	maxCookieLen  int
+	timestampSkew time.Duration
 	keyPrefix     string
+	environment   string
 	serializer    SessionSerializer
+
+	trackCreatedAt bool
+	absTimeout     time.Duration
+	reapDeletes    bool
+	deleteGrace    time.Duration
+	pruneEmpty     bool
+	validator      func(*sessions.Session) error
+	idValidator    func(id string) error
+	schemaVersion  int
+	migrator       func(version int, values map[interface{}]interface{}) (int, map[interface{}]interface{}, error)
+	skipUnchanged  bool
+	separateFlash  bool
+	legacyBase64   bool
+	valuesMutex    bool
+	trackActivity  bool
+	preserveTTL    bool
+	ttlFunc        func(*sessions.Session) int
+	onOversize     func(session *sessions.Session, size, limit int)
+	keepIDPadding  bool
+	keyHashing     bool
+	keyBuilder     func(*sessions.Session) string
+	compactCookie  bool
+	fallback       *GoRediStore
+	tracer         trace.Tracer
+	observer       Observer
+	cookieless     bool
+	cookieNameFunc func(r *http.Request, baseName string) string
+	cookiePolicy   InvalidCookiePolicy
+	fingerprint    func(*http.Request) string
+	hashTag        func(*sessions.Session) string
+	dbSelector     func(*sessions.Session) int
+	dbClients      *dbClients
+	breaker        *circuitBreaker
+	health         *healthChecker
+	states         *sessionStates
+	writeBehind    *writeBehind
+	inflight       int32 // save/load/delete calls in progress, see CloseContext
+	derived        bool  // Client is owned by the store this one was derived from
+}
+
+// StoreConfig is a read-only snapshot of a store's configuration.
+type StoreConfig struct {
+	KeyPrefix      string
+	Environment    string
+	MaxLength      int
+	DefaultMaxAge  int    // redis TTL, in seconds, for a MaxAge == 0 session
+	CookieMaxAge   int    // Options.MaxAge
+	SerializerName string // Go type of the serializer, e.g. "goredistore.GobSerializer"
 }
 
+// Config returns a snapshot of the store's current configuration.
+func (s *GoRediStore) Config() StoreConfig {
+	return StoreConfig{
+		KeyPrefix:      s.keyPrefix,
+		Environment:    s.environment,
+		MaxLength:      s.maxLength,
+		DefaultMaxAge:  s.DefaultMaxAge,
+		CookieMaxAge:   s.Options.MaxAge,
+		SerializerName: fmt.Sprintf("%T", s.serializer),
+	}
+}
+
+// SetMaxCookieLength sets the maximum length, name included, of the cookie
+// holding a session's encoded ID. Save returns ErrCookieTooLarge before
+// storing a session whose cookie is longer, e.g. because of a custom codec,
+// instead of emitting a cookie browsers silently drop. Unlike
+// SetCookieMaxLength, which only configures securecookie codecs, it checks
+// the output of any codec. If l is 0 cookies are not checked.
+// Default: 4093.
+func (s *GoRediStore) SetMaxCookieLength(l int) {
+	if l >= 0 {
+		s.maxCookieLen = l
+	}
+}
+
+// GoRediStore must stay a drop-in gorilla sessions.Store.
+var _ sessions.Store = (*GoRediStore)(nil)
+
 // SetMaxLength sets RediStore.maxLength if the `l` argument is greater or equal 0
 // maxLength restricts the maximum length of new sessions to l.
 // If l is 0 there is no limit to the size of a session, use with caution.
@@ -113,233 +513,2283 @@ func (s *GoRediStore) SetMaxLength(l int) {
 	}
 }
 
+// SetMaxLoadSize sets the maximum size, in bytes, of a stored value that load
+// will deserialize. Larger values are rejected with ErrSessionTooBig before
+// being handed to the serializer, guarding against memory exhaustion from a
+// poisoned key. If l is 0 there is no limit.
+// Default: 0.
+func (s *GoRediStore) SetMaxLoadSize(l int) {
+	if l >= 0 {
+		s.maxLoadSize = l
+	}
+}
+
 // SetKeyPrefix set the prefix
 func (s *GoRediStore) SetKeyPrefix(p string) {
 	s.keyPrefix = p
 }
 
-// SetSerializer sets the serializer
-func (s *GoRediStore) SetSerializer(ss SessionSerializer) {
-	s.serializer = ss
+// SetEnvironment tags every key the store uses with env, as in
+// "staging:session_ID", so stores for several environments can share a
+// redis without seeing each other's sessions. SCAN-based helpers such as
+// Count and Flush only cover the store's environment. The tag is outside any
+// hash tag in the session ID, so hash-tagged sessions still share a slot;
+// env must not contain braces itself. Pass "" for no tag.
+// Default: "".
+func (s *GoRediStore) SetEnvironment(env string) {
+	s.environment = env
 }
 
-// SetMaxAge restricts the maximum age, in seconds, of the session record
-// both in database and a browser. This is to change session storage configuration.
-// If you want just to remove session use your session `s` object and change it's
-// `Options.MaxAge` to -1, as specified in
-//    http://godoc.org/github.com/gorilla/sessions#Options
-//
-// Default is the one provided by this package value - `sessionExpire`.
-// Set it to 0 for no restriction.
-// Because we use `MaxAge` also in SecureCookie crypting algorithm you should
-// use this function to change `MaxAge` value.
-func (s *GoRediStore) SetMaxAge(v int) {
-	var c *securecookie.SecureCookie
-	var ok bool
-	s.Options.MaxAge = v
-	for i := range s.Codecs {
-		if c, ok = s.Codecs[i].(*securecookie.SecureCookie); ok {
-			c.MaxAge(v)
-		} else {
-			fmt.Printf("Can't change MaxAge on codec %v\n", s.Codecs[i])
-		}
+// environmentTag returns what SetEnvironment prepends to every key.
+func (s *GoRediStore) environmentTag() string {
+	if s.environment == "" {
+		return ""
 	}
+	return s.environment + ":"
 }
 
-// NewRediStore returns a new RediStore.
-// size: maximum number of idle connections.
-func NewGoRediStore(size int, network, address, password string, keyPairs ...[]byte) (*GoRediStore, error) {
-	c := redis.NewClient(&redis.Options{
-		Network:     network,
-		Addr:        address,
-		PoolSize:    size,
-		IdleTimeout: 240 * time.Second,
-		// OnConnect: func(c *redis.Conn) error {
-		// 	return c.ClientGetName().Err()
-		// },
-		Password: password,
-	})
+// prefix returns the part of every session key before the ID: the
+// environment tag, if any, and the key prefix.
+func (s *GoRediStore) prefix() string {
+	return s.environmentTag() + s.keyPrefix
+}
 
-	return NewGoRediStoreWithPool(c, keyPairs...)
+// SetDeleteGracePeriod makes deleting a session, e.g. saving it with
+// MaxAge <= 0, shorten its TTL to d instead of removing it right away, so
+// concurrent requests already under way can still load it for a moment
+// instead of losing it mid-action. The tradeoff is that a deleted session,
+// say on logout, stays usable by anyone holding its cookie for d; keep it to
+// a second or two. The response cookie is expired immediately either way. If
+// d is 0 sessions are deleted at once.
+// Default: 0.
+func (s *GoRediStore) SetDeleteGracePeriod(d time.Duration) {
+	if d >= 0 {
+		s.deleteGrace = d
+	}
 }
 
-// NewRediStoreWithDB - like NewRedisStore but accepts `DB` parameter to select
-// redis DB instead of using the default one ("0")
-func NewGoRediStoreWithDB(size int, network, address, password string, DB int, keyPairs ...[]byte) (*GoRediStore, error) {
-	c := redis.NewClient(&redis.Options{
-		Network:     network,
-		Addr:        address,
-		PoolSize:    size,
-		IdleTimeout: 240 * time.Second,
-		// OnConnect: func(c *redis.Conn) error {
-		// 	return c.ClientGetName().Err()
-		// },
-		Password: password,
-		DB:       DB,
-	})
-	return NewGoRediStoreWithPool(c, keyPairs...)
+// SetPruneEmpty makes Save treat a session without values like one marked for
+// deletion: its redis key is deleted and its cookie expired instead of
+// storing an empty session.
+// Default: false.
+func (s *GoRediStore) SetPruneEmpty(b bool) {
+	s.pruneEmpty = b
 }
 
-// NewRediStoreWithPool instantiates a RediStore with a *redis.Pool passed in.
-func NewGoRediStoreWithPool(client *redis.Client, keyPairs ...[]byte) (*GoRediStore, error) {
-	rs := &GoRediStore{
-		// https://godoc.org/github.com/go-redis/redis#Client
-		Client: client,
-		Codecs: securecookie.CodecsFromPairs(keyPairs...),
-		Options: &sessions.Options{
-			Path:   "/",
-			MaxAge: sessionExpire,
-		},
-		DefaultMaxAge: 60 * 20, // 20 minutes is a reasonable default
-		maxLength:     4096,
-		keyPrefix:     "session_",
-		serializer:    GobSerializer{},
+// SetValidator sets a function called at the start of Save and SaveMerge.
+// If it returns an error, saving is aborted before redis or the response
+// cookies are touched, and the error is returned.
+func (s *GoRediStore) SetValidator(fn func(*sessions.Session) error) {
+	s.validator = fn
+}
+
+// SetIDValidator sets a function that checks session IDs that were not
+// generated by the store, such as one set by the application to restore a
+// known session, before the session is saved. It should reject IDs that could
+// address other keys, e.g. ones containing spaces or glob wildcards. If it
+// returns an error, saving is aborted and the error returned. IDs read from
+// cookies are checked too. Pass nil to use preset IDs verbatim.
+func (s *GoRediStore) SetIDValidator(fn func(id string) error) {
+	s.idValidator = fn
+}
+
+// ensureID generates an ID for a session without one, and validates a
+// preset one.
+func (s *GoRediStore) ensureID(session *sessions.Session) error {
+	if session.ID == "" {
+		session.ID = s.newID(session)
+		return nil
 	}
-	_, err := rs.ping()
-	return rs, err
+	if s.idValidator != nil {
+		return s.idValidator(session.ID)
+	}
+	return nil
 }
 
-// Close closes the underlying *redis.Pool
-func (s *GoRediStore) Close() error {
-	return s.Client.Close()
+// SetLegacyBase64Decode makes loading accept session values stored
+// base64-encoded, as some versions of boj/redistore wrote them, so sessions
+// written by older code can be read during a migration. A value that is
+// valid standard base64 is decoded before deserialization; sessions are
+// saved in the plain format. JSON objects start with '{' and Gob streams
+// hold bytes outside the base64 alphabet, so current values are unaffected.
+// Default: false.
+func (s *GoRediStore) SetLegacyBase64Decode(b bool) {
+	s.legacyBase64 = b
 }
 
-// Get returns a session for the given name after adding it to the registry.
-//
-// See gorilla/sessions FilesystemStore.Get().
-func (s *GoRediStore) Get(r *http.Request, name string) (*sessions.Session, error) {
-	return sessions.GetRegistry(r).Get(s, name)
+// decodeLegacyBase64 returns data decoded if it is standard base64, and data
+// otherwise.
+func decodeLegacyBase64(data []byte) []byte {
+	if len(data) == 0 || len(data)%4 != 0 {
+		return data
+	}
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Strict().Decode(decoded, data)
+	if err != nil {
+		return data
+	}
+	return decoded[:n]
 }
 
-// New returns a session for the given name without adding it to the registry.
+// SetPreserveTTLOnUpdate makes saving a session that was loaded from redis
+// write it with SET XX KEEPTTL, so updating its values keeps its current
+// expiry. This turns off sliding expiration: such sessions expire their
+// original TTL after creation however often they are saved, unless extended
+// with SetTTL or LoadAndTouch. New sessions, and loaded ones whose key has
+// expired in the meantime, still get a TTL. It has no effect while
+// write-behind is enabled. Requires Redis 6.0 or later.
+// Default: false.
+func (s *GoRediStore) SetPreserveTTLOnUpdate(b bool) {
+	s.preserveTTL = b
+}
+
+// SetSkipUnchanged makes Save skip rewriting a loaded session whose
+// serialized values are byte-for-byte what was loaded. Its TTL is still
+// refreshed, with a PEXPIRE instead of a full SETEX. Since only identical
+// bytes are detected, this works best with JSONSerializer: Gob output of a
+// session with several values differs between encodings, so those are always
+// rewritten.
+// Default: false.
+func (s *GoRediStore) SetSkipUnchanged(b bool) {
+	s.skipUnchanged = b
+}
+
+// SetOnOversize sets a function called when a session's serialized size
+// exceeds maxLength on save, just before ErrSessionTooBig is returned. The
+// hook may mutate the session, e.g. trim low-priority values, but the current
+// save still fails: the caller retries it.
+func (s *GoRediStore) SetOnOversize(fn func(session *sessions.Session, size, limit int)) {
+	s.onOversize = fn
+}
+
+// SetTTLFunc sets a function that computes the redis TTL, in seconds, of each
+// saved session from its contents, e.g. shorter for admin sessions and longer
+// for remember-me ones. A result of 0 falls back to Options.MaxAge and
+// DefaultMaxAge. The cookie's MaxAge is not affected.
+func (s *GoRediStore) SetTTLFunc(fn func(*sessions.Session) int) {
+	s.ttlFunc = fn
+}
+
+// SetKeyHashing makes the store key sessions by a hash of their ID, so the
+// redis key becomes keyPrefix+hex(sha256(ID)) while the cookie keeps the ID
+// itself: reading the keys out of redis no longer yields usable session
+// cookies. A hash tag at the start of the ID (see SetHashTag) is kept in
+// clear so cluster colocation still works. Sessions saved before the change
+// are no longer found. Helpers that walk the keyspace, such as Export and
+// VerifyKeys, see hashed IDs.
+// Default: false.
+func (s *GoRediStore) SetKeyHashing(b bool) {
+	s.keyHashing = b
+}
+
+// SetKeyBuilder sets a function that returns the full redis key of a
+// session, replacing the store's prefix, environment tag and ID, e.g.
+// "user:{42}:session" to share keys with existing redis data. Loading,
+// saving and deleting a session, and its companion keys, use it; methods
+// taking a bare ID, such as Exists, TTL and Replace, and helpers that walk
+// the keyspace under the prefix, such as Count and Flush, do not.
 //
-// See gorilla/sessions FilesystemStore.New().
-func (s *GoRediStore) New(r *http.Request, name string) (*sessions.Session, error) {
-	var (
-		err error
-		ok  bool
-	)
-	session := sessions.NewSession(s, name)
-	// make a copy
-	options := *s.Options
-	session.Options = &options
-	session.IsNew = true
-	if c, errCookie := r.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
-		if err == nil {
-			ok, err = s.load(session)
-			session.IsNew = !(err == nil && ok) // not new if no error and data available
-		}
+// fn must derive the key from the session's ID and name only: when a session
+// is loaded from a cookie its values are still empty. The key is then only
+// as unguessable as the ID, so a deterministic ID, e.g. a user ID, lets
+// anyone holding a validly signed cookie for it read the session, and two
+// sessions mapped to the same key overwrite each other. Keep keys unique per
+// session and never build them from unsigned client input.
+//
+// Derive does not copy the builder, which would ignore the derived store's
+// prefix: set one on the derived store if it needs one.
+// Default: nil, keyPrefix+ID.
+func (s *GoRediStore) SetKeyBuilder(fn func(session *sessions.Session) string) {
+	s.keyBuilder = fn
+}
+
+// SetKeepIDPadding controls whether generated session IDs keep their trailing
+// base32 "=" padding. IDs already set on a session are always used verbatim.
+// Default: false, padding is trimmed.
+func (s *GoRediStore) SetKeepIDPadding(b bool) {
+	s.keepIDPadding = b
+}
+
+// SetHashTag sets a function whose result is embedded in generated session
+// IDs as a Redis Cluster hash tag, so a new session's key becomes
+// keyPrefix+"{"+tag+"}"+random. Sessions with the same tag hash to the same
+// slot and can be read together with MGET, e.g. all sessions of one user.
+// fn is called when Save first assigns an ID; braces are removed from its
+// result and an empty result generates an untagged ID. Because the tag is
+// part of the ID, loading and deleting need no extra information, and
+// companion keys share their session's slot.
+// Default: nil, no tag.
+func (s *GoRediStore) SetHashTag(fn func(*sessions.Session) string) {
+	s.hashTag = fn
+}
+
+// SetCompactCookie makes generated session IDs 16 random bytes instead of 32,
+// which shortens the encoded cookie by about 48 bytes for apps close to the
+// browser's 4KB cookie limit. 128 bits of entropy is still far beyond what can
+// be guessed online, but halves the margin of the default 256 bits. Existing
+// sessions keep their IDs.
+// Default: false.
+func (s *GoRediStore) SetCompactCookie(b bool) {
+	s.compactCookie = b
+}
+
+// InvalidCookiePolicy selects how New handles a session cookie that fails to
+// decode, e.g. because its signature does not verify or it has expired.
+type InvalidCookiePolicy int
+
+const (
+	// NewSession ignores the bad cookie and returns a new session without
+	// an error.
+	NewSession InvalidCookiePolicy = iota
+	// ReturnError returns a new session along with the decode error.
+	ReturnError
+)
+
+// SetInvalidCookiePolicy sets how New handles a cookie that fails to decode.
+// Errors loading a decoded session from redis are always returned.
+// Default: NewSession.
+func (s *GoRediStore) SetInvalidCookiePolicy(p InvalidCookiePolicy) {
+	s.cookiePolicy = p
+}
+
+// SetOptionsForName sets the default Options of sessions with the given name,
+// e.g. a longer MaxAge for "auth" than for "flash", used instead of the
+// store's Options. Securecookie codecs still reject cookies older than the
+// MaxAge set with SetMaxAge, so a per-name MaxAge should not exceed it.
+func (s *GoRediStore) SetOptionsForName(name string, opts sessions.Options) {
+	if s.nameOptions == nil {
+		s.nameOptions = make(map[string]sessions.Options)
 	}
-	return session, err
+	s.nameOptions[name] = opts
 }
 
-// Save adds a single session to the response.
-func (s *GoRediStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
-	// Marked for deletion.
-	if session.Options.MaxAge <= 0 {
-		if err := s.delete(session); err != nil {
-			return err
-		}
-		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
-	} else {
-		// Build an alphanumeric key for the redis store.
-		if session.ID == "" {
-			session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
-		}
-		if err := s.save(session); err != nil {
-			return err
-		}
-		encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
-		if err != nil {
-			return err
-		}
-		http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+// options returns a copy of the default Options for sessions with the given
+// name.
+func (s *GoRediStore) options(name string) *sessions.Options {
+	if options, ok := s.nameOptions[name]; ok {
+		return &options
 	}
-	return nil
+	options := *s.Options
+	return &options
 }
 
-// Delete removes the session from redis, and sets the cookie to expire.
+// SetFallbackStore sets a store that is read when a session is not found in
+// this one, e.g. the old instance during a migration between two redis
+// servers. A session found in the fallback is copied into this store, so the
+// new instance warms up as sessions are used. Pass nil to disable.
+func (s *GoRediStore) SetFallbackStore(other *GoRediStore) {
+	s.fallback = other
+}
+
+// SetTracer sets an OpenTelemetry tracer used to wrap save, load and delete
+// in spans named "goredistore.save" and so on, carrying the session name,
+// redis key and payload size, and recording errors. Spans are children of the
+// request's context. A nil tracer disables tracing.
+func (s *GoRediStore) SetTracer(tracer trace.Tracer) {
+	s.tracer = tracer
+}
+
+// SetCookieless stops the store from writing Set-Cookie headers, for sessions
+// whose ID is passed to the client some other way. Save still generates IDs
+// and writes to redis.
+// Default: false.
+func (s *GoRediStore) SetCookieless(b bool) {
+	s.cookieless = b
+}
+
+// SetCookieNameFunc sets a function deriving the name of the cookie that
+// carries a session from the request and the session name, e.g. to add a
+// tenant suffix so apps on subdomains of one parent domain do not collide:
+// "sessionid" becomes "sessionid_tenantA". The derived name is used to read,
+// write and expire the cookie, and to sign it, so a cookie issued under one
+// name does not decode under another. Sessions keep their base name.
+func (s *GoRediStore) SetCookieNameFunc(fn func(r *http.Request, baseName string) string) {
+	s.cookieNameFunc = fn
+}
+
+// SetFingerprint binds sessions to a client fingerprint, such as the user
+// agent or client IP, computed by fn. Save stores a hash of the request's
+// fingerprint next to the session and New returns a fresh session when the
+// fingerprint of a later request differs, which limits the use of stolen
+// cookies. The stored session itself is left untouched.
 //
-// WARNING: This method should be considered deprecated since it is not exposed via the gorilla/sessions interface.
-// Set session.Options.MaxAge = -1 and call Save instead. - July 18th, 2013
-func (s *GoRediStore) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
-	conn := s.Client
-	defer conn.Close()
-	if _, err := conn.Do("DEL", s.keyPrefix+session.ID).Result(); err != nil {
-		return err
-	}
-	// Set cookie to expire.
-	options := *session.Options
-	options.MaxAge = -1
-	http.SetCookie(w, sessions.NewCookie(session.Name(), "", &options))
-	// Clear session values.
-	for k := range session.Values {
-		delete(session.Values, k)
+// Pick the inputs with care: client IPs change on mobile networks and behind
+// load balancers, and user agents change on browser updates, each logging the
+// user out. Fingerprints are only stored hashed, but are still personal data.
+// Pass nil to disable.
+func (s *GoRediStore) SetFingerprint(fn func(*http.Request) string) {
+	s.fingerprint = fn
+}
+
+// SetCircuitBreaker makes save, load and delete fail fast with ErrCircuitOpen
+// after `failures` consecutive failures to reach redis, instead of waiting on
+// an unavailable server. After `cooldown` a single probe request is let
+// through; if it succeeds the breaker closes, otherwise it stays open for
+// another cooldown. Error replies from a reachable server do not count as
+// failures. A failures value of 0 disables the breaker.
+func (s *GoRediStore) SetCircuitBreaker(failures int, cooldown time.Duration) {
+	if failures <= 0 {
+		s.breaker = nil
+		return
 	}
-	return nil
+	s.breaker = newCircuitBreaker(failures, cooldown)
 }
 
-// ping does an internal ping against a server to check if it is alive.
-func (s *GoRediStore) ping() (bool, error) {
-	conn := s.Client
-	defer conn.Close()
-	data, err := conn.Do("PING").String()
-	if err != nil || data == "" {
-		return false, err
+// SetCodecs replaces the codecs used to encode and decode session cookies.
+// Cookies encoded by the previous codecs no longer decode unless one of them
+// is kept in codecs.
+func (s *GoRediStore) SetCodecs(codecs []securecookie.Codec) {
+	s.Codecs = codecs
+}
+
+// SetSerializer sets the serializer
+func (s *GoRediStore) SetSerializer(ss SessionSerializer) {
+	s.serializer = ss
+}
+
+// SetTrackCreatedAt enables stamping the creation time of new sessions into a
+// companion redis key on their first save. The timestamp is kept alive with the
+// session and can be read back with CreatedAt.
+// Default: false.
+func (s *GoRediStore) SetTrackCreatedAt(b bool) {
+	s.trackCreatedAt = b
+}
+
+// SetAbsoluteTimeout caps the lifetime of sessions at d after their creation,
+// on top of the sliding TTL each save sets: a save never extends a session's
+// TTL past its creation time plus d, and saving a session older than that
+// fails with ErrSessionExpired. Save, SaveWithTTL, SaveMerge, LoadAndTouch,
+// SetTTL and KeepAlive are all capped. Creation times are tracked as with
+// SetTrackCreatedAt, which this enables; a session saved before tracking
+// started is treated as created on its next save or LoadAndTouch, while
+// SetTTL leaves its TTL uncapped until then. If d is 0 there is no cap.
+// Default: 0.
+func (s *GoRediStore) SetAbsoluteTimeout(d time.Duration) {
+	if d >= 0 {
+		s.absTimeout = d
+	}
+	if d > 0 {
+		s.trackCreatedAt = true
 	}
-	return (data == "PONG"), nil
 }
 
-// save stores the session in redis.
-func (s *GoRediStore) save(session *sessions.Session) error {
-	b, err := s.serializer.Serialize(session)
-	if err != nil {
-		return err
+// absoluteTTL returns ttl capped to the time left before the session reaches
+// the absolute timeout, or ErrSessionExpired if there is none left.
+func (s *GoRediStore) absoluteTTL(ctx context.Context, c *redis.Client, session *sessions.Session, ttl time.Duration) (time.Duration, error) {
+	if s.absTimeout == 0 {
+		return ttl, nil
 	}
-	if s.maxLength != 0 && len(b) > s.maxLength {
-		return errors.New("SessionStore: the value to store is too big")
+	created := time.Now()
+	if !session.IsNew {
+		key := s.sessionKey(session) + createdAtSuffix
+		ns, err := c.Get(ctx, key).Int64()
+		if err == redis.Nil {
+			err = c.SetNX(ctx, key, created.UnixNano(), ttl).Err()
+		} else if err == nil {
+			created = time.Unix(0, ns)
+		}
+		if err != nil {
+			return 0, err
+		}
 	}
-	conn := s.Client
-	defer conn.Close()
-	if err = conn.ClientGetName().Err(); err != nil {
-		return err
+	left := time.Until(created.Add(s.absTimeout)).Truncate(time.Millisecond)
+	if left <= 0 {
+		return 0, ErrSessionExpired
 	}
-	age := session.Options.MaxAge
-	if age == 0 {
-		age = s.DefaultMaxAge
+	if left < ttl {
+		return left, nil
 	}
-	_, err = conn.Do("SETEX", s.keyPrefix+session.ID, age, b).Result()
-	return err
+	return ttl, nil
 }
 
-// load reads the session from redis.
-// returns true if there is a sessoin data in DB
-func (s *GoRediStore) load(session *sessions.Session) (bool, error) {
-	conn := s.Client
-	defer conn.Close()
-	if err := conn.ClientGetName().Err(); err != nil {
-		return false, err
+// CreatedAt returns the time the session with the given ID was first saved.
+// It returns ErrSessionNotFound if no creation time is stored for the ID,
+// which is also the case for sessions saved while tracking was disabled.
+func (s *GoRediStore) CreatedAt(ctx context.Context, id string) (time.Time, error) {
+	ns, err := s.Client.Get(ctx, s.key(id)+createdAtSuffix).Int64()
+	if err == redis.Nil {
+		return time.Time{}, ErrSessionNotFound
 	}
-	data, err := conn.Do("GET", s.keyPrefix+session.ID).String()
 	if err != nil {
-		return false, err
-	}
-	if data == "" {
-		return false, nil // no data was associated with this key
+		return time.Time{}, err
 	}
+	return time.Unix(0, ns), nil
+}
 
-	b := []byte(data)
+// NoExpiry is the TTL reported for a session key that never expires.
+const NoExpiry time.Duration = -1
 
-	return true, s.serializer.Deserialize(b, session)
+// TTL returns the remaining time to live of the session with the given ID,
+// or NoExpiry if its key has no expiry. It returns ErrSessionNotFound if the
+// key does not exist.
+func (s *GoRediStore) TTL(ctx context.Context, id string) (time.Duration, error) {
+	if s.writeBehind != nil {
+		if err := s.writeBehind.flushKey(ctx, s.key(id)); err != nil {
+			return 0, err
+		}
+	}
+	ms, err := s.Client.Do(ctx, "PTTL", s.key(id)).Int64()
+	if err != nil {
+		return 0, err
+	}
+	switch ms {
+	case -2:
+		return 0, ErrSessionNotFound
+	case -1:
+		return NoExpiry, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
 }
 
-// delete removes keys from redis if MaxAge<0
-func (s *GoRediStore) delete(session *sessions.Session) error {
-	conn := s.Client
-	defer conn.Close()
-	if _, err := conn.Do("DEL", s.keyPrefix+session.ID).Result(); err != nil {
+// Exists reports whether a session with the given ID is stored.
+func (s *GoRediStore) Exists(ctx context.Context, id string) (bool, error) {
+	if s.writeBehind != nil {
+		if err := s.writeBehind.flushKey(ctx, s.key(id)); err != nil {
+			return false, err
+		}
+	}
+	n, err := s.Client.Exists(ctx, s.key(id)).Result()
+	return n == 1, err
+}
+
+// SetTTL sets the remaining time to live of the stored session with the given
+// ID to d, without loading or rewriting its values. It can both shorten and
+// lengthen a session, but not past SetAbsoluteTimeout's cap for a session
+// whose creation time is tracked; past the cap it returns ErrSessionExpired.
+// It returns ErrSessionNotFound if the key does not exist, and ErrInvalidTTL
+// if d is not positive, which would delete it, or too long.
+func (s *GoRediStore) SetTTL(ctx context.Context, id string, d time.Duration) error {
+	if err := checkTTL(d); err != nil {
+		return err
+	}
+	c := s.Client
+	key := s.key(id)
+	if s.writeBehind != nil {
+		if err := s.writeBehind.flushKey(ctx, key); err != nil {
+			return err
+		}
+	}
+	if s.absTimeout != 0 {
+		ns, err := c.Get(ctx, key+createdAtSuffix).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			left := time.Until(time.Unix(0, ns).Add(s.absTimeout)).Truncate(time.Millisecond)
+			if left <= 0 {
+				return ErrSessionExpired
+			}
+			if left < d {
+				d = left
+			}
+		}
+	}
+	expire := c.Expire
+	if d%time.Second != 0 {
+		expire = c.PExpire
+	}
+	ok, err := expire(ctx, key, d).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	for _, k := range companionKeys(key) {
+		if err = expire(ctx, k, d).Err(); err != nil {
+			return err
+		}
+	}
+	return s.recordActivity(ctx, id)
+}
+
+// MoveToPrefix moves the session with the given ID, and its companion keys,
+// from the store's prefix to newPrefix, in the same environment, using
+// RENAME, which keeps the value and the TTL. The target is overwritten if it
+// exists. It returns ErrSessionNotFound if the session does not exist. On
+// Redis Cluster both keys must hash to the same slot.
+func (s *GoRediStore) MoveToPrefix(ctx context.Context, id, newPrefix string) error {
+	c := s.Client
+	key, newKey := s.key(id), s.environmentTag()+newPrefix+s.keyID(id)
+	if err := c.Rename(ctx, key, newKey).Err(); err != nil {
+		if isNoSuchKey(err) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	for _, suffix := range companionSuffixes {
+		if err := c.Rename(ctx, key+suffix, newKey+suffix).Err(); err != nil && !isNoSuchKey(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isNoSuchKey reports whether err is the error RENAME returns for a missing
+// source key.
+func isNoSuchKey(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), "no such key")
+}
+
+// SetReapDeletes makes Reap delete the stale keys it finds rather than only
+// reporting them.
+// Default: false.
+func (s *GoRediStore) SetReapDeletes(b bool) {
+	s.reapDeletes = b
+}
+
+// Reap scans the store's keys and reports how many it scanned and how many
+// are stale: keys without an expiry, which Redis will never evict, and keys
+// whose TTL has run out but are still present. If SetReapDeletes(true) was
+// called, stale keys are deleted along with their companion keys.
+//
+// Reap walks the whole keyspace with SCAN and is meant for monitoring jobs,
+// not request paths. If it fails part way, the counts so far are returned
+// with the error.
+func (s *GoRediStore) Reap(ctx context.Context) (scanned, expired int, err error) {
+	c := s.Client
+	err = s.scan(ctx, func(keys []string) error {
+		scanned += len(keys)
+		cmds := make([]*redis.Cmd, len(keys))
+		if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				cmds[i] = pipe.Do(ctx, "PTTL", key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		var stale []string
+		for i, cmd := range cmds {
+			// -1 means no expiry, -2 that the key is already gone.
+			if ttl, _ := cmd.Int64(); ttl == -1 || ttl == 0 {
+				stale = append(stale, keys[i])
+				stale = append(stale, companionKeys(keys[i])...)
+				expired++
+			}
+		}
+		if s.reapDeletes && len(stale) > 0 {
+			return c.Del(ctx, stale...).Err()
+		}
+		return nil
+	})
+	return scanned, expired, err
+}
+
+// SessionInfo describes a stored session, as listed by ListByTTL.
+type SessionInfo struct {
+	ID  string        // the key after the prefix; with key hashing, the hashed ID
+	TTL time.Duration // remaining time to live, or NoExpiry
+}
+
+// ListByTTL returns up to limit sessions under the store's prefix, those
+// expiring soonest first, e.g. for an admin view of sessions about to
+// expire. Sessions without an expiry come last. If limit is 0 or less every
+// session is returned.
+//
+// ListByTTL walks the whole keyspace with SCAN, reading TTLs in one pipeline
+// per batch, and holds every session in memory to sort them: it is O(n) and
+// meant for admin and ops tools, not request paths.
+func (s *GoRediStore) ListByTTL(ctx context.Context, limit int) ([]SessionInfo, error) {
+	c := s.Client
+	var list []SessionInfo
+	err := s.scan(ctx, func(keys []string) error {
+		cmds := make([]*redis.Cmd, len(keys))
+		if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				cmds[i] = pipe.Do(ctx, "PTTL", key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for i, cmd := range cmds {
+			ms, err := cmd.Int64()
+			if err != nil {
+				return err
+			}
+			info := SessionInfo{ID: keys[i][len(s.prefix()):], TTL: time.Duration(ms) * time.Millisecond}
+			switch ms {
+			case -2:
+				continue // expired since the scan
+			case -1:
+				info.TTL = NoExpiry
+			}
+			list = append(list, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].TTL, list[j].TTL
+		if a == NoExpiry || b == NoExpiry {
+			return b == NoExpiry && a != NoExpiry
+		}
+		return a < b
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Flush deletes every session under the store's prefix, along with the
+// sessions' companion keys and activity times, e.g. for test teardown, and
+// returns the number of sessions deleted. Keys outside the prefix are never
+// touched; Flush does not use FLUSHDB. It returns ErrNoKeyPrefix if the store
+// has no prefix.
+//
+// Flush walks the keyspace with SCAN and deletes each batch with UNLINK, so
+// redis frees the values without blocking. It is not atomic: a session saved
+// while Flush runs may survive it. If it fails part way, e.g. on a failover,
+// the sessions deleted so far are counted along with the error, and calling
+// Flush again resumes the job.
+func (s *GoRediStore) Flush(ctx context.Context) (int, error) {
+	if s.prefix() == "" {
+		return 0, ErrNoKeyPrefix
+	}
+	c := s.Client
+	n := 0
+	err := s.scan(ctx, func(keys []string) error {
+		batch := make([]string, 0, len(keys)*(1+len(companionSuffixes)))
+		for _, key := range keys {
+			batch = append(batch, key)
+			batch = append(batch, companionKeys(key)...)
+		}
+		if err := c.Unlink(ctx, batch...).Err(); err != nil {
+			return err
+		}
+		n += len(keys)
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, c.Unlink(ctx, s.activityKey()).Err()
+}
+
+// Count returns the number of sessions under the store's prefix. It walks
+// the keyspace with SCAN, so it is O(n) in the size of the database and the
+// result is approximate while sessions are saved or expire. If the walk fails
+// part way, the sessions counted so far are returned with the error.
+func (s *GoRediStore) Count(ctx context.Context) (int, error) {
+	n := 0
+	err := s.scan(ctx, func(keys []string) error {
+		n += len(keys)
+		return nil
+	})
+	return n, err
+}
+
+// generatedIDPattern matches the IDs newID generates: base32, optionally
+// padded, after an optional hash tag.
+var generatedIDPattern = regexp.MustCompile(`^(\{[^{}]*\})?[A-Z2-7]+=*$`)
+
+// hashedIDPattern matches the key suffix of an ID under key hashing.
+var hashedIDPattern = regexp.MustCompile(`^(\{[^{}]*\})?[0-9a-f]{64}$`)
+
+// VerifyKeys SCANs the keys under the store's prefix and reports, one line
+// per key, those that look misconfigured: keys whose ID starts with the
+// prefix again, as when IDs that already carry a prefix are stored under
+// SetKeyPrefix, and keys whose ID is not in the format the store generates.
+// IDs set by the application are reported as such, so on stores that use
+// them only the double prefix findings are meaningful.
+func (s *GoRediStore) VerifyKeys(ctx context.Context) (issues []string, err error) {
+	pattern := generatedIDPattern
+	if s.keyHashing {
+		pattern = hashedIDPattern
+	}
+	err = s.scan(ctx, func(keys []string) error {
+		for _, key := range keys {
+			id := key[len(s.prefix()):]
+			switch {
+			case s.keyPrefix != "" && strings.HasPrefix(id, s.keyPrefix):
+				issues = append(issues, fmt.Sprintf("%s: key prefix %q is repeated", key, s.keyPrefix))
+			case !pattern.MatchString(id):
+				issues = append(issues, fmt.Sprintf("%s: session ID %q is not in the generated format", key, id))
+			}
+		}
+		return nil
+	})
+	sort.Strings(issues)
+	return issues, err
+}
+
+// Rewrite applies transform to the stored value of every session under the
+// store's prefix and writes the result back with the key's remaining TTL,
+// e.g. to re-encrypt sessions after rotating an at-rest encryption key. It
+// returns the number of sessions rewritten. Keys that expire while Rewrite
+// runs are skipped rather than recreated. If transform returns an error,
+// Rewrite stops and returns it.
+//
+// Rewrite walks the whole keyspace with SCAN and is not atomic: a session
+// saved between reading and writing it back loses that save. If it stops
+// part way, the sessions rewritten so far are counted along with the error.
+func (s *GoRediStore) Rewrite(ctx context.Context, transform func(old []byte) ([]byte, error)) (int, error) {
+	c := s.Client
+	n := 0
+	err := s.scan(ctx, func(keys []string) error {
+		for _, key := range keys {
+			var get *redis.StringCmd
+			var pttl *redis.Cmd
+			if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				get = pipe.Get(ctx, key)
+				pttl = pipe.Do(ctx, "PTTL", key)
+				return nil
+			}); err != nil && err != redis.Nil {
+				return err
+			}
+			old, err := get.Bytes()
+			if err == redis.Nil {
+				continue // expired since the scan
+			}
+			if err != nil {
+				return err
+			}
+			ttl, err := pttl.Int64()
+			if err != nil {
+				return err
+			}
+			if ttl == -2 {
+				continue
+			}
+			b, err := transform(old)
+			if err != nil {
+				return err
+			}
+			var expiration time.Duration // -1: no expiry to preserve
+			if ttl > 0 {
+				expiration = time.Duration(ttl) * time.Millisecond
+			}
+			ok, err := c.SetXX(ctx, key, b, expiration).Result()
+			if err != nil {
+				return err
+			}
+			if ok {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// SetMaxAge restricts the maximum age, in seconds, of the session record
+// both in database and a browser. This is to change session storage configuration.
+// If you want just to remove session use your session `s` object and change it's
+// `Options.MaxAge` to -1, as specified in
+//
+//	http://godoc.org/github.com/gorilla/sessions#Options
+//
+// Default is the one provided by this package value - `sessionExpire`.
+// Set it to 0 for no restriction.
+// Because we use `MaxAge` also in SecureCookie crypting algorithm you should
+// use this function to change `MaxAge` value.
+func (s *GoRediStore) SetMaxAge(v int) {
+	s.Options.MaxAge = v
+	s.setCodecMaxAge()
+}
+
+// SetTimestampSkew makes the securecookie codecs accept cookies up to d
+// older than Options.MaxAge, so cookies issued by a server whose clock runs
+// behind are not rejected as expired early. Cookies stamped in the future,
+// by a server whose clock runs ahead, are always accepted by securecookie
+// unless a MinAge is set on the codecs. The skew is rounded up to whole
+// seconds, the resolution of cookie timestamps, and is kept across SetMaxAge
+// calls. It only relaxes the cookie check: the session's redis TTL is not
+// extended.
+// Default: 0.
+func (s *GoRediStore) SetTimestampSkew(d time.Duration) {
+	if d >= 0 {
+		s.timestampSkew = d
+		s.setCodecMaxAge()
+	}
+}
+
+// setCodecMaxAge sets MaxAge on every securecookie codec to Options.MaxAge,
+// plus the timestamp skew if there is a limit.
+func (s *GoRediStore) setCodecMaxAge() {
+	v := s.Options.MaxAge
+	if v > 0 {
+		v += int((s.timestampSkew + time.Second - 1) / time.Second)
+	}
+	var c *securecookie.SecureCookie
+	var ok bool
+	for i := range s.Codecs {
+		if c, ok = s.Codecs[i].(*securecookie.SecureCookie); ok {
+			c.MaxAge(v)
+		} else {
+			fmt.Printf("Can't change MaxAge on codec %v\n", s.Codecs[i])
+		}
+	}
+}
+
+// SetCookieMaxLength sets the maximum length of an encoded cookie value on
+// every securecookie codec, like SetMaxAge does for MaxAge. It is separate
+// from SetMaxLength, which bounds the value stored in redis. If n is 0 there
+// is no limit.
+// Default is securecookie's 4096.
+func (s *GoRediStore) SetCookieMaxLength(n int) {
+	var c *securecookie.SecureCookie
+	var ok bool
+	for i := range s.Codecs {
+		if c, ok = s.Codecs[i].(*securecookie.SecureCookie); ok {
+			c.MaxLength(n)
+		} else {
+			fmt.Printf("Can't change MaxLength on codec %v\n", s.Codecs[i])
+		}
+	}
+}
+
+// NewRediStore returns a new RediStore.
+// size: maximum number of idle connections.
+func NewGoRediStore(size int, network, address, password string, keyPairs ...[]byte) (*GoRediStore, error) {
+	c := redis.NewClient(&redis.Options{
+		Network:         network,
+		Addr:            address,
+		PoolSize:        size,
+		ConnMaxIdleTime: 240 * time.Second,
+		Password:        password,
+	})
+
+	return NewGoRediStoreWithPool(c, keyPairs...)
+}
+
+// NewRediStoreWithDB - like NewRedisStore but accepts `DB` parameter to select
+// redis DB instead of using the default one ("0")
+func NewGoRediStoreWithDB(size int, network, address, password string, DB int, keyPairs ...[]byte) (*GoRediStore, error) {
+	c := redis.NewClient(&redis.Options{
+		Network:         network,
+		Addr:            address,
+		PoolSize:        size,
+		ConnMaxIdleTime: 240 * time.Second,
+		Password:        password,
+		DB:              DB,
+	})
+	return NewGoRediStoreWithPool(c, keyPairs...)
+}
+
+// NewGoRediStoreWithUnixSocket returns a new store connected to redis over
+// the unix socket at path, for redis running on the same host. The pool uses
+// go-redis's default size.
+func NewGoRediStoreWithUnixSocket(path, password string, keyPairs ...[]byte) (*GoRediStore, error) {
+	c := redis.NewClient(&redis.Options{
+		Network:         "unix",
+		Addr:            path,
+		ConnMaxIdleTime: 240 * time.Second,
+		Password:        password,
+	})
+	return NewGoRediStoreWithPool(c, keyPairs...)
+}
+
+// NewRediStoreWithPool instantiates a RediStore with a *redis.Pool passed in.
+func NewGoRediStoreWithPool(client *redis.Client, keyPairs ...[]byte) (*GoRediStore, error) {
+	return NewGoRediStoreWithOptions(client, keyPairs)
+}
+
+// NewGoRediStoreWithCodecs is like NewGoRediStoreWithPool, but takes
+// preconfigured cookie codecs, e.g. with a custom hash function or cookie
+// serializer, instead of building them from key pairs. SetMaxAge and
+// SetCookieMaxLength only reach codecs that are *securecookie.SecureCookie.
+func NewGoRediStoreWithCodecs(client *redis.Client, codecs []securecookie.Codec) (*GoRediStore, error) {
+	rs, err := NewGoRediStoreWithOptions(client, nil)
+	rs.Codecs = codecs
+	return rs, err
+}
+
+// Option configures a GoRediStore at construction time, before it is used.
+type Option func(*GoRediStore)
+
+// WithSerializer sets the serializer used by the store from its first save.
+func WithSerializer(ss SessionSerializer) Option {
+	return func(s *GoRediStore) {
+		s.serializer = ss
+	}
+}
+
+// WithOnConnect sets a hook the client runs on every new connection before
+// using it, e.g. to issue CLIENT SETNAME or READONLY. It is set on the
+// client's options, so it also applies to other users of the client, and
+// only to connections opened from then on: the store's initial ping opens
+// one, but a client that was used before may hold idle connections that
+// never run it. A returned error fails the connection.
+func WithOnConnect(fn func(ctx context.Context, cn *redis.Conn) error) Option {
+	return func(s *GoRediStore) {
+		s.Client.Options().OnConnect = fn
+	}
+}
+
+// WithGobTypes registers each value's concrete type with gob.Register, so
+// GobSerializer can encode and decode session values of those types, keeping
+// the registrations an application needs in one place. gob's registry is
+// global: the types are registered for the whole process, not just this
+// store, and registering a type again under the same name is a no-op.
+// Like gob.Register, it panics when a type or name is already registered
+// differently, failing at construction rather than on the first save.
+func WithGobTypes(values ...interface{}) Option {
+	return func(s *GoRediStore) {
+		for _, v := range values {
+			gob.Register(v)
+		}
+	}
+}
+
+// WithProtocol selects the RESP version the client negotiates with HELLO on
+// new connections: 3 for RESP3, e.g. for push messages and typed replies, or
+// 2 for servers before Redis 6 and proxies that reject HELLO. go-redis v9
+// uses 3 by default and falls back to 2 if the server does not know HELLO.
+// Like WithOnConnect it is set on the client's options, so it applies to
+// connections opened from then on. The store reads the same values under
+// both: its commands reply with strings, integers and arrays, which RESP3
+// does not change; only Do replies for map-shaped commands, e.g. HELLO or
+// CONFIG GET, become maps instead of flat arrays.
+func WithProtocol(p int) Option {
+	return func(s *GoRediStore) {
+		s.Client.Options().Protocol = p
+	}
+}
+
+// NewGoRediStoreWithOptions is like NewGoRediStoreWithPool but applies opts to
+// the store before the initial ping.
+func NewGoRediStoreWithOptions(client *redis.Client, keyPairs [][]byte, opts ...Option) (*GoRediStore, error) {
+	rs := &GoRediStore{
+		// https://pkg.go.dev/github.com/redis/go-redis/v9#Client
+		Client: client,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: sessionExpire,
+		},
+		DefaultMaxAge: 60 * 20, // 20 minutes is a reasonable default
+		maxLength:     4096,
+		maxCookieLen:  4093,
+		keyPrefix:     "session_",
+		serializer:    GobSerializer{},
+		health:        &healthChecker{},
+		states:        &sessionStates{},
+	}
+	client.AddHook(closedHook{})
+	for _, opt := range opts {
+		opt(rs)
+	}
+	_, err := rs.ping()
+	return rs, err
+}
+
+// Derive returns a new store that shares this store's Client and Codecs but
+// uses keyPrefix and its own copy of every other setting, so its serializer,
+// Options and limits can be changed independently. This runs several logical
+// session stores over one connection pool.
+//
+// The client stays owned by the store it was created with: Close and
+// CloseContext on a derived store do not close it. A SetKeyBuilder function
+// is not copied, since it would bypass keyPrefix.
+func (s *GoRediStore) Derive(keyPrefix string) *GoRediStore {
+	d := *s
+	options := *s.Options
+	d.Options = &options
+	d.nameOptions = make(map[string]sessions.Options, len(s.nameOptions))
+	for name, opts := range s.nameOptions {
+		d.nameOptions[name] = opts
+	}
+	d.keyPrefix = keyPrefix
+	d.keyBuilder = nil
+	d.inflight = 0
+	d.health = &healthChecker{}
+	d.writeBehind = nil
+	d.dbClients = nil
+	d.SetDBSelector(s.dbSelector)
+	d.derived = true
+	return &d
+}
+
+// Close closes the underlying *redis.Pool, after stopping health checks and
+// flushing the write-behind buffer, if enabled.
+func (s *GoRediStore) Close() error {
+	s.StopHealthChecks()
+	var err error
+	if s.writeBehind != nil {
+		err = s.writeBehind.close()
+		s.writeBehind = nil
+	}
+	if s.dbClients != nil {
+		if cerr := s.dbClients.close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.derived {
+		return err
+	}
+	if cerr := s.Client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closePollInterval is how often CloseContext checks for in-flight operations.
+const closePollInterval = 5 * time.Millisecond
+
+// CloseContext is like Close, but first attempts a final PING and waits for
+// in-flight save, load and delete operations to finish. If ctx is done before
+// they finish, the client is closed anyway and the context error returned.
+func (s *GoRediStore) CloseContext(ctx context.Context) error {
+	pingErr := s.Client.Ping(ctx).Err()
+	waitErr := s.waitIdle(ctx)
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+	return pingErr
+}
+
+// waitIdle blocks until no operations are in flight or ctx is done.
+func (s *GoRediStore) waitIdle(ctx context.Context) error {
+	t := time.NewTicker(closePollInterval)
+	defer t.Stop()
+	for atomic.LoadInt32(&s.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// track marks an operation as in flight until the returned func is called.
+func (s *GoRediStore) track() func() {
+	atomic.AddInt32(&s.inflight, 1)
+	return func() { atomic.AddInt32(&s.inflight, -1) }
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See gorilla/sessions FilesystemStore.Get().
+func (s *GoRediStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// GetWithResult is like Get, but also reports whether the session was loaded
+// from redis by this call. It is false for a new session and for one the
+// request's registry already held from an earlier Get, which is the case to
+// look for when debugging stale sessions.
+func (s *GoRediStore) GetWithResult(r *http.Request, name string) (session *sessions.Session, loaded bool, err error) {
+	registry := sessions.GetRegistry(r)
+	registry.Get(resultStore{s, &loaded}, name)
+	// Get binds the session to the store it is passed: look it up again, now
+	// from the registry's cache along with any error, so session.Store() is s
+	// and not the wrapper.
+	session, err = registry.Get(s, name)
+	return session, loaded, err
+}
+
+// resultStore records in loaded whether its New loaded the session, for
+// GetWithResult, which rebinds the sessions it creates to the underlying
+// store.
+type resultStore struct {
+	*GoRediStore
+	loaded *bool
+}
+
+func (s resultStore) New(r *http.Request, name string) (session *sessions.Session, err error) {
+	session, *s.loaded, err = s.NewWithResult(r, name)
+	return session, err
+}
+
+// VerifyCookie decodes the session cookie with the given name and returns the
+// session ID it carries, checking its signature and age with the store's
+// codecs but without reading from redis. A valid cookie does not imply the
+// session still exists. It returns http.ErrNoCookie if there is no cookie.
+func (s *GoRediStore) VerifyCookie(r *http.Request, name string) (id string, err error) {
+	name = s.cookieName(r, name)
+	c, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	if err = securecookie.DecodeMulti(name, c.Value, &id, s.Codecs...); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// New returns a session for the given name without adding it to the registry.
+//
+// See gorilla/sessions FilesystemStore.New().
+func (s *GoRediStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, _, err := s.NewWithResult(r, name)
+	return session, err
+}
+
+// NewWithResult is like New, but also reports whether the session was loaded
+// from redis. It is false for a new session, including one started over
+// because its cookie named a session that no longer exists.
+func (s *GoRediStore) NewWithResult(r *http.Request, name string) (session *sessions.Session, loaded bool, err error) {
+	var ok bool
+	session = sessions.NewSession(s, name)
+	session.Options = s.options(name)
+	session.IsNew = true
+	cookie := s.cookieName(r, name)
+	if c, errCookie := r.Cookie(cookie); errCookie == nil {
+		err = securecookie.DecodeMulti(cookie, c.Value, &session.ID, s.Codecs...)
+		if err != nil && s.cookiePolicy == NewSession {
+			err = nil
+		} else if err == nil {
+			ctx := requestContext(r)
+			ok, err = s.load(ctx, session)
+			if err == nil && ok && s.fingerprint != nil {
+				if ok, err = s.matchFingerprint(ctx, r, session); err == nil && !ok {
+					// Possibly hijacked: start over with a fresh session.
+					session.ID = ""
+					session.Values = make(map[interface{}]interface{})
+				}
+			}
+			session.IsNew = !(err == nil && ok) // not new if no error and data available
+		}
+	}
+	return session, !session.IsNew, err
+}
+
+// PreloadByIDs loads the sessions with the given IDs in a single MGET,
+// without reading cookies or adding them to a request registry. Sessions are
+// created with the given name and the default Options for that name, and
+// upgraded by SetSchemaMigrator as on load. IDs with no stored data are
+// omitted from the result.
+func (s *GoRediStore) PreloadByIDs(ctx context.Context, name string, ids []string) (map[string]*sessions.Session, error) {
+	result := make(map[string]*sessions.Session, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.key(id)
+	}
+	values, err := s.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue // nil: no such key
+		}
+		if s.maxLoadSize != 0 && len(data) > s.maxLoadSize {
+			return nil, ErrSessionTooBig
+		}
+		session := sessions.NewSession(s, name)
+		session.Options = s.options(name)
+		session.ID = ids[i]
+		if err = s.deserialize([]byte(data), session); err != nil {
+			return nil, err
+		}
+		if err = s.migrate(ctx, s.Client, session); err != nil {
+			return nil, err
+		}
+		result[ids[i]] = session
+	}
+	return result, nil
+}
+
+// RawValue returns the bytes stored in redis for the session with the given
+// ID, exactly as written and without deserializing them, e.g. to diagnose a
+// serializer mismatch. A value still held in the write-behind buffer is not
+// returned. It returns ErrSessionNotFound if the key does not exist.
+func (s *GoRediStore) RawValue(ctx context.Context, id string) ([]byte, error) {
+	data, err := s.Client.Get(ctx, s.key(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	return data, err
+}
+
+// MemoryUsage returns the number of bytes redis uses to hold the session
+// with the given ID, as reported by MEMORY USAGE, which includes the key and
+// allocation overhead, e.g. for capacity planning. Companion keys are not
+// counted. On servers without MEMORY USAGE (before Redis 4.0) it falls back
+// to STRLEN, which only counts the stored value and so underestimates the
+// footprint. It returns ErrSessionNotFound if the key does not exist.
+func (s *GoRediStore) MemoryUsage(ctx context.Context, id string) (int64, error) {
+	key := s.key(id)
+	n, err := s.Client.MemoryUsage(ctx, key).Result()
+	if isUnknownCommand(err) {
+		if n, err = s.Client.StrLen(ctx, key).Result(); err == nil && n == 0 {
+			err = redis.Nil
+		}
+	}
+	if err == redis.Nil {
+		return 0, ErrSessionNotFound
+	}
+	return n, err
+}
+
+// isUnknownCommand reports whether err is redis's reply to a command the
+// server does not implement.
+func isUnknownCommand(err error) bool {
+	var rerr redis.Error
+	return errors.As(err, &rerr) && strings.HasPrefix(rerr.Error(), "ERR unknown command")
+}
+
+// ValueKeys returns the sorted top-level keys of the values stored for the
+// session with the given ID, formatted with fmt.Sprint. With JSONSerializer
+// only the top-level object is parsed, so keys are listed even if some values
+// would not decode; any other serializer fully deserializes the session. It
+// returns ErrSessionNotFound if the session does not exist.
+func (s *GoRediStore) ValueKeys(ctx context.Context, id string) ([]string, error) {
+	data, err := s.Client.Get(ctx, s.key(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.maxLoadSize != 0 && len(data) > s.maxLoadSize {
+		return nil, ErrSessionTooBig
+	}
+	var keys []string
+	if _, ok := s.serializer.(JSONSerializer); ok && !bytes.HasPrefix(data, []byte(formatMagic)) {
+		var m map[string]json.RawMessage
+		if err = json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		delete(m, metadataField)
+		for k := range m {
+			keys = append(keys, k)
+		}
+	} else {
+		session := sessions.NewSession(s, "")
+		if err = s.deserialize(data, session); err != nil {
+			return nil, err
+		}
+		for k := range session.Values {
+			keys = append(keys, fmt.Sprint(k))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Save adds a single session to the response.
+//
+// w may be nil, e.g. in tests and background jobs: the session is then only
+// persisted to redis and no cookie is emitted.
+func (s *GoRediStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	defer s.lockValues(session)()
+	if s.validator != nil {
+		if err := s.validator(session); err != nil {
+			return err
+		}
+	}
+	// Marked for deletion.
+	ctx := requestContext(r)
+	if session.Options.MaxAge <= 0 {
+		if err := s.delete(ctx, session); err != nil {
+			return err
+		}
+		s.setCookie(w, sessions.NewCookie(s.cookieName(r, session.Name()), "", session.Options))
+	} else if s.pruneEmpty && len(s.storedSession(session).Values) == 0 {
+		if err := s.delete(ctx, session); err != nil {
+			return err
+		}
+		options := *session.Options
+		options.MaxAge = -1
+		s.setCookie(w, sessions.NewCookie(s.cookieName(r, session.Name()), "", &options))
+	} else {
+		ttl, err := s.ttl(session)
+		if err != nil {
+			return err
+		}
+		return s.persist(ctx, r, w, session, ttl)
+	}
+	return nil
+}
+
+// SaveWithTTL is like Save, but stores the session in redis with the given
+// TTL instead of one derived from its MaxAge. TTLs with sub-second precision,
+// e.g. for short-lived CSRF or OTP sessions, are written with PSETEX. The
+// cookie still follows session.Options. A session marked for deletion is
+// deleted as by Save.
+func (s *GoRediStore) SaveWithTTL(r *http.Request, w http.ResponseWriter, session *sessions.Session, ttl time.Duration) error {
+	if session.Options.MaxAge <= 0 {
+		return s.Save(r, w, session)
+	}
+	defer s.lockValues(session)()
+	if s.validator != nil {
+		if err := s.validator(session); err != nil {
+			return err
+		}
+	}
+	return s.persist(requestContext(r), r, w, session, ttl)
+}
+
+// persist stores the session in redis with the given TTL, generating an ID
+// if needed, and adds its cookie to the response.
+func (s *GoRediStore) persist(ctx context.Context, r *http.Request, w http.ResponseWriter, session *sessions.Session, ttl time.Duration) error {
+	if err := s.ensureID(session); err != nil {
+		return err
+	}
+	cookie, err := s.encodeCookie(r, session)
+	if err != nil {
+		return err
+	}
+	if err := s.save(ctx, session, ttl); err != nil {
+		return err
+	}
+	if err := s.saveFingerprint(ctx, r, session, ttl); err != nil {
+		return err
+	}
+	s.setCookie(w, cookie)
+	return nil
+}
+
+// SaveMerge is like Save, but only writes the keys this request changed over
+// the values currently stored in redis, instead of overwriting them. Keys set,
+// changed or deleted since the session was loaded or last saved are applied;
+// every other key keeps its stored value, so changes written by a concurrent
+// request, e.g. in another tab, survive. A session that was never loaded
+// writes all of its keys.
+//
+// Merging is best-effort: the read and the write are guarded by WATCH/MULTI
+// so a concurrent write between them causes a retry, values are compared
+// with reflect.DeepEqual, and concurrent changes to the same key are still
+// last-writer-wins.
+func (s *GoRediStore) SaveMerge(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		return s.Save(r, w, session)
+	}
+	defer s.lockValues(session)()
+	if s.validator != nil {
+		if err := s.validator(session); err != nil {
+			return err
+		}
+	}
+	if err := s.ensureID(session); err != nil {
+		return err
+	}
+	ttl, err := s.ttl(session)
+	if err != nil {
+		return err
+	}
+	cookie, err := s.encodeCookie(r, session)
+	if err != nil {
+		return err
+	}
+	ctx := requestContext(r)
+	if err = s.merge(ctx, session, ttl); err != nil {
+		return err
+	}
+	if err = s.saveFingerprint(ctx, r, session, ttl); err != nil {
+		return err
+	}
+	s.setCookie(w, cookie)
+	return nil
+}
+
+// newID builds an alphanumeric key for the redis store, prefixed with the
+// session's hash tag if one is configured.
+func (s *GoRediStore) newID(session *sessions.Session) string {
+	n := 32
+	if s.compactCookie {
+		n = 16
+	}
+	id := base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(n))
+	if !s.keepIDPadding {
+		id = strings.TrimRight(id, "=")
+	}
+	if s.hashTag == nil {
+		return id
+	}
+	tag := hashTagBraces.Replace(s.hashTag(session))
+	if tag == "" {
+		return id
+	}
+	return "{" + tag + "}" + id
+}
+
+// hashTagBraces removes braces, which would change the slot a tag hashes to.
+var hashTagBraces = strings.NewReplacer("{", "", "}", "")
+
+// encodeCookie builds the cookie holding the encoded session ID, enforcing
+// maxCookieLen.
+func (s *GoRediStore) encodeCookie(r *http.Request, session *sessions.Session) (*http.Cookie, error) {
+	name := s.cookieName(r, session.Name())
+	encoded, err := securecookie.EncodeMulti(name, session.ID, s.Codecs...)
+	if err != nil {
+		return nil, err
+	}
+	if !s.cookieless && s.maxCookieLen != 0 && len(name)+1+len(encoded) > s.maxCookieLen {
+		return nil, ErrCookieTooLarge
+	}
+	return sessions.NewCookie(name, encoded, session.Options), nil
+}
+
+// saveFingerprint stores the hashed fingerprint of r next to the session, if
+// a fingerprint function is set.
+func (s *GoRediStore) saveFingerprint(ctx context.Context, r *http.Request, session *sessions.Session, ttl time.Duration) error {
+	if s.fingerprint == nil || r == nil {
+		return nil
+	}
+	return s.client(session).Set(ctx, s.sessionKey(session)+fingerprintSuffix, hashFingerprint(s.fingerprint(r)), ttl).Err()
+}
+
+// matchFingerprint reports whether the fingerprint of r matches the one stored
+// for the session. Sessions without a stored fingerprint match.
+func (s *GoRediStore) matchFingerprint(ctx context.Context, r *http.Request, session *sessions.Session) (bool, error) {
+	stored, err := s.client(session).Get(ctx, s.sessionKey(session)+fingerprintSuffix).Result()
+	if err == redis.Nil {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return stored == hashFingerprint(s.fingerprint(r)), nil
+}
+
+// hashFingerprint hashes a fingerprint so raw client data is not stored.
+func hashFingerprint(fp string) string {
+	sum := sha256.Sum256([]byte(fp))
+	return hex.EncodeToString(sum[:])
+}
+
+// cookieName returns the name of the cookie for the session name, as derived
+// by the store's cookie name function. Without a request the session name is
+// used as is.
+func (s *GoRediStore) cookieName(r *http.Request, name string) string {
+	if s.cookieNameFunc == nil || r == nil {
+		return name
+	}
+	return s.cookieNameFunc(r, name)
+}
+
+// setCookie adds the cookie to the response unless the store is cookieless
+// or there is no response, e.g. when saving from a background job.
+func (s *GoRediStore) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	if s.cookieless || w == nil {
+		return
+	}
+	http.SetCookie(w, cookie)
+}
+
+// Delete removes the session from redis, and sets the cookie to expire.
+//
+// WARNING: This method should be considered deprecated since it is not exposed via the gorilla/sessions interface.
+// Set session.Options.MaxAge = -1 and call Save instead. - July 18th, 2013
+func (s *GoRediStore) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if err := s.delete(requestContext(r), session); err != nil {
+		return err
+	}
+	// Set cookie to expire.
+	options := *session.Options
+	options.MaxAge = -1
+	s.setCookie(w, sessions.NewCookie(s.cookieName(r, session.Name()), "", &options))
+	// Clear session values.
+	for k := range session.Values {
+		delete(session.Values, k)
+	}
+	return nil
+}
+
+// Destroy invalidates the request's session with the given name, e.g. on
+// logout: it deletes the session from redis, expires its cookie and clears
+// its values, going through Save with MaxAge -1. The session is taken from
+// the request's registry, so later Gets in the same request see it cleared.
+func (s *GoRediStore) Destroy(r *http.Request, w http.ResponseWriter, name string) error {
+	session, err := s.Get(r, name)
+	if err != nil {
+		return err
+	}
+	session.Options.MaxAge = -1
+	if session.ID == "" {
+		// Nothing was stored: only expire the cookie.
+		s.setCookie(w, sessions.NewCookie(s.cookieName(r, name), "", session.Options))
+	} else if err = s.Save(r, w, session); err != nil {
+		return err
+	}
+	for k := range session.Values {
+		delete(session.Values, k)
+	}
+	return nil
+}
+
+// RefreshCookie re-encodes the session's ID with the store's current Codecs
+// and the session's Options and adds the cookie to the response, without
+// contacting redis, e.g. after changing SameSite or rotating codec keys
+// mid-session. It returns ErrSessionNotFound if the session has no ID yet,
+// and ErrCookieTooLarge as Save does. There is no request to pass to a
+// SetCookieNameFunc, so on a store with one it returns ErrCookieNameFunc
+// rather than set a cookie under a name New would never read.
+func (s *GoRediStore) RefreshCookie(w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		return ErrSessionNotFound
+	}
+	if s.cookieNameFunc != nil {
+		return ErrCookieNameFunc
+	}
+	cookie, err := s.encodeCookie(nil, session)
+	if err != nil {
+		return err
+	}
+	s.setCookie(w, cookie)
+	return nil
+}
+
+// ping does an internal ping against a server to check if it is alive.
+func (s *GoRediStore) ping() (bool, error) {
+	data, err := s.Client.Do(context.Background(), "PING").Text()
+	if err != nil || data == "" {
+		return false, err
+	}
+	return (data == "PONG"), nil
+}
+
+// save stores the session in redis.
+// The TTL is written with SETEX, or PSETEX if it has sub-second precision.
+func (s *GoRediStore) save(ctx context.Context, session *sessions.Session, ttl time.Duration) (err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "save", session)
+	defer func() { endSpan(span, err) }()
+	var size int
+	defer s.observeSave(time.Now(), &size, &err)
+	defer s.observe("save", time.Now(), &err)
+	if err = checkTTL(ttl); err != nil {
+		return err
+	}
+	flashes, restore := s.takeFlashes(session)
+	defer restore()
+	b, err := s.serialize(ctx, session)
+	if err != nil {
+		return err
+	}
+	size = len(b)
+	span.SetAttributes(attribute.Int("session.size", len(b)))
+	c := s.client(session)
+	err = s.guard(func() error {
+		ttl, err := s.absoluteTTL(ctx, c, session, ttl)
+		if err != nil {
+			return err
+		}
+		if s.preserveTTL && !session.IsNew && s.writeBehind == nil {
+			err = c.SetArgs(ctx, s.sessionKey(session), b, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+			if err != redis.Nil {
+				if err == nil {
+					s.setSynced(session, b)
+				}
+				return err
+			}
+			// The key is gone: write it again with a TTL.
+		}
+		if s.skipUnchanged && bytes.Equal(s.syncedValue(session), b) {
+			// Refresh the TTL only; if the key is gone, write it again.
+			var ok bool
+			if ok, err = c.PExpire(ctx, s.sessionKey(session), ttl).Result(); err != nil {
+				return err
+			}
+			if ok {
+				if s.trackCreatedAt {
+					err = s.saveCreatedAt(ctx, c, session, ttl)
+				}
+				return err
+			}
+		}
+		if s.writeBehind != nil && c == s.Client {
+			err = s.writeBehind.buffer(s.sessionKey(session), b, ttl)
+		} else if ttl%time.Second == 0 {
+			_, err = c.Do(ctx, "SETEX", s.sessionKey(session), int64(ttl/time.Second), b).Result()
+		} else {
+			_, err = c.Do(ctx, "PSETEX", s.sessionKey(session), int64(ttl/time.Millisecond), b).Result()
+		}
+		if err != nil {
+			return err
+		}
+		s.setSynced(session, b)
+		if s.trackCreatedAt {
+			err = s.saveCreatedAt(ctx, c, session, ttl)
+		}
+		return err
+	})
+	if err != nil || flashes == nil {
+		return err
+	}
+	return s.guard(func() error {
+		return s.saveFlashes(ctx, c, session, flashes, ttl)
+	})
+}
+
+// maxMergeRetries bounds how often merge retries after a concurrent write.
+const maxMergeRetries = 10
+
+// merge applies the keys of the session that changed since it was loaded or
+// saved to the values stored in redis. On success session.Values holds the
+// merged result.
+func (s *GoRediStore) merge(ctx context.Context, session *sessions.Session, ttl time.Duration) (err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "merge", session)
+	defer func() { endSpan(span, err) }()
+	var size int
+	defer s.observeSave(time.Now(), &size, &err)
+	defer s.observe("merge", time.Now(), &err)
+	key := s.sessionKey(session)
+	var base map[interface{}]interface{} // the values as loaded or last saved
+	if synced := s.syncedValue(session); synced != nil {
+		loaded := sessions.NewSession(s, session.Name())
+		if err = s.deserialize(synced, loaded); err != nil {
+			return err
+		}
+		base = loaded.Values
+	}
+	var merged *sessions.Session
+	fn := func(tx *redis.Tx) error {
+		merged = sessions.NewSession(s, session.Name())
+		merged.ID = session.ID
+		merged.Options = session.Options
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err = s.deserialize(data, merged); err != nil {
+				return err
+			}
+		}
+		for k, v := range session.Values {
+			if old, ok := base[k]; !ok || !reflect.DeepEqual(old, v) {
+				merged.Values[k] = v
+			}
+		}
+		for k := range base {
+			if _, ok := session.Values[k]; !ok {
+				delete(merged.Values, k)
+			}
+		}
+		if st := s.states.get(session); st != nil && st.meta != nil {
+			s.states.ensure(merged).meta = st.meta
+		}
+		b, err := s.serialize(ctx, merged)
+		if err != nil {
+			return err
+		}
+		size = len(b)
+		span.SetAttributes(attribute.Int("session.size", len(b)))
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, b, ttl)
+			return nil
+		})
+		if err == nil {
+			s.setSynced(merged, b)
+		}
+		return err
+	}
+	c := s.client(session)
+	err = s.guard(func() error {
+		var err error
+		if s.writeBehind != nil {
+			if err = s.writeBehind.flushKey(ctx, key); err != nil {
+				return err
+			}
+		}
+		if ttl, err = s.absoluteTTL(ctx, c, session, ttl); err != nil {
+			return err
+		}
+		for i := 0; i < maxMergeRetries; i++ {
+			if err = c.Watch(ctx, fn, key); err != redis.TxFailedErr {
+				break
+			}
+		}
+		return err
+	})
+	if err != nil {
 		return err
 	}
+	session.Values = merged.Values
+	st := s.states.ensure(session)
+	st.meta, st.synced = s.states.get(merged).meta, s.states.get(merged).synced
+	if s.trackCreatedAt {
+		err = s.saveCreatedAt(ctx, c, session, ttl)
+	}
+	return err
+}
+
+// serialize encodes the session values and metadata, enforcing maxLength.
+// A format set on ctx with ContextWithFormat overrides the store's
+// serializer.
+func (s *GoRediStore) serialize(ctx context.Context, session *sessions.Session) ([]byte, error) {
+	ser := s.serializer
+	var magic string
+	if format, ok := ctx.Value(formatKey{}).(string); ok {
+		multi, isMulti := ser.(MultiSerializer)
+		if !isMulti {
+			magic = formatMagic
+		}
+		multi.Preferred = format
+		ser = multi
+	}
+	s.stampSchemaVersion(session)
+	var b []byte
+	var size int
+	var err error
+	if st, ok := ser.(StreamSerializer); ok {
+		w := &limitedBuffer{limit: s.maxLength}
+		err = st.SerializeTo(w, s.storedSession(session))
+		b, size = w.buf.Bytes(), w.n
+	} else {
+		b, err = ser.Serialize(s.storedSession(session))
+		size = len(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if magic != "" {
+		b = append([]byte(magic), b...)
+		size += len(magic)
+	}
+	if s.maxLength != 0 && size > s.maxLength {
+		if s.onOversize != nil {
+			s.onOversize(session, size, s.maxLength)
+		}
+		return nil, ErrSessionTooBig
+	}
+	return b, nil
+}
+
+// limitedBuffer buffers up to limit bytes, or all if limit is 0, and counts
+// every byte written.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	n     int
+}
+
+func (w *limitedBuffer) Write(p []byte) (int, error) {
+	w.n += len(p)
+	if w.limit == 0 || w.n <= w.limit {
+		w.buf.Write(p)
+	} else if w.buf.Len() > 0 {
+		w.buf = bytes.Buffer{} // over the limit: the value will be rejected
+	}
+	return len(p), nil
+}
+
+// deserialize decodes stored session values and metadata into session.
+func (s *GoRediStore) deserialize(data []byte, session *sessions.Session) error {
+	stored := data
+	if s.legacyBase64 {
+		data = decodeLegacyBase64(data)
+	}
+	ser := s.serializer
+	if _, ok := ser.(MultiSerializer); !ok && bytes.HasPrefix(data, []byte(formatMagic)) {
+		ser = MultiSerializer{} // saved with a format from ContextWithFormat
+		data = data[len(formatMagic):]
+	}
+	var err error
+	if st, ok := ser.(StreamSerializer); ok {
+		err = st.DeserializeFrom(bytes.NewReader(data), session)
+	} else {
+		err = ser.Deserialize(data, session)
+	}
+	if err != nil {
+		return NewError(CodeCorrupt, err)
+	}
+	s.restoreMetadata(session)
+	// Keep what is stored, so a legacy value is always rewritten.
+	s.setSynced(session, stored)
+	return nil
+}
+
+// age returns the redis TTL, in seconds, for the session.
+func (s *GoRediStore) age(session *sessions.Session) int {
+	if s.ttlFunc != nil {
+		if age := s.ttlFunc(session); age != 0 {
+			return age
+		}
+	}
+	if session.Options.MaxAge == 0 {
+		return s.DefaultMaxAge
+	}
+	return session.Options.MaxAge
+}
+
+// maxTTLSeconds caps session TTLs. Larger ages are almost certainly a
+// misconfiguration and would overflow time.Duration or be rejected by SETEX
+// with an opaque error.
+const maxTTLSeconds = math.MaxInt32
+
+// ttl is like age as a time.Duration. It returns ErrInvalidTTL if the age is
+// not positive or exceeds maxTTLSeconds.
+func (s *GoRediStore) ttl(session *sessions.Session) (time.Duration, error) {
+	age := s.age(session)
+	if age <= 0 || age > maxTTLSeconds {
+		return 0, fmt.Errorf("%w: %d seconds", ErrInvalidTTL, age)
+	}
+	return time.Duration(age) * time.Second, nil
+}
+
+// checkTTL returns ErrInvalidTTL if ttl is not positive or exceeds
+// maxTTLSeconds.
+func checkTTL(ttl time.Duration) error {
+	if ttl <= 0 || ttl > maxTTLSeconds*time.Second {
+		return fmt.Errorf("%w: %v", ErrInvalidTTL, ttl)
+	}
 	return nil
 }
+
+// saveCreatedAt stamps the creation time of a new session into its companion
+// key, or refreshes the companion's TTL for an existing one. SETNX guarantees
+// the original timestamp is never overwritten.
+func (s *GoRediStore) saveCreatedAt(ctx context.Context, c *redis.Client, session *sessions.Session, ttl time.Duration) error {
+	key := s.sessionKey(session) + createdAtSuffix
+	if session.IsNew {
+		return c.SetNX(ctx, key, time.Now().UnixNano(), ttl).Err()
+	}
+	return c.Expire(ctx, key, ttl).Err()
+}
+
+// load reads the session from redis.
+// returns true if there is a sessoin data in DB
+func (s *GoRediStore) load(ctx context.Context, session *sessions.Session) (ok bool, err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "load", session)
+	defer func() { endSpan(span, err) }()
+	defer s.observe("load", time.Now(), &err)
+	var data []byte
+	err = s.guard(func() error {
+		if s.writeBehind != nil {
+			var ok bool
+			if data, ok = s.writeBehind.get(s.sessionKey(session)); ok {
+				return nil
+			}
+		}
+		data, err = s.client(session).Get(ctx, s.sessionKey(session)).Bytes()
+		return err
+	})
+	if err == redis.Nil {
+		return s.loadFallback(ctx, session) // no data was associated with this key
+	}
+	if err != nil {
+		return false, err
+	}
+	// A present key is deserialized even if its value is empty: only a
+	// missing key means there is no session.
+	span.SetAttributes(attribute.Int("session.size", len(data)))
+	if s.maxLoadSize != 0 && len(data) > s.maxLoadSize {
+		return false, ErrSessionTooBig
+	}
+	if err = s.deserialize(data, session); err != nil {
+		return true, err
+	}
+	if err = s.recordActivity(ctx, session.ID); err != nil {
+		return true, err
+	}
+	if err = s.migrate(ctx, s.client(session), session); err != nil || !s.separateFlash {
+		return true, err
+	}
+	return true, s.guard(func() error {
+		return s.loadFlashes(ctx, s.client(session), session)
+	})
+}
+
+// loadAndTouchScript GETs KEYS[1] and, if it exists, sets the TTL of every
+// key to ARGV[1] milliseconds, returning the value and the TTL set. With an
+// absolute timeout of ARGV[2] milliseconds, the TTL is capped at the creation
+// time in KEYS[2], in unix nanoseconds, plus the timeout; ARGV[3] and ARGV[4]
+// are the current time in milliseconds and nanoseconds, stamped into KEYS[2]
+// if it is missing. A session past the cap is left as it is and reported with
+// a TTL of 0.
+var loadAndTouchScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if not v then
+	return false
+end
+local ttl = tonumber(ARGV[1])
+local timeout = tonumber(ARGV[2])
+if timeout > 0 then
+	local now = tonumber(ARGV[3])
+	local created = redis.call("GET", KEYS[2])
+	if created then
+		created = math.floor(tonumber(created) / 1000000)
+	else
+		redis.call("SET", KEYS[2], ARGV[4], "PX", ttl)
+		created = now
+	end
+	local left = created + timeout - now
+	if left <= 0 then
+		return {v, 0}
+	end
+	if left < ttl then
+		ttl = left
+	end
+end
+for _, key in ipairs(KEYS) do
+	redis.call("PEXPIRE", key, ttl)
+end
+return {v, ttl}
+`)
+
+// LoadAndTouch loads the session with session.ID, like New does from a
+// cookie, and extends its TTL to ttl in the same round-trip, for sliding
+// expiration middleware. Its companion keys are extended too. It reports
+// whether the session was found; a session missing from redis is not looked
+// up in the fallback store. The TTL is capped by SetAbsoluteTimeout as on
+// save, and a session past the cap is not extended and fails with
+// ErrSessionExpired.
+func (s *GoRediStore) LoadAndTouch(ctx context.Context, session *sessions.Session, ttl time.Duration) (ok bool, err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "load", session)
+	defer func() { endSpan(span, err) }()
+	defer s.observe("load", time.Now(), &err)
+	if err = checkTTL(ttl); err != nil {
+		return false, err
+	}
+	key := s.sessionKey(session)
+	c := s.client(session)
+	var data string
+	var touched int64
+	err = s.guard(func() error {
+		if s.writeBehind != nil {
+			if err := s.writeBehind.flushKey(ctx, key); err != nil {
+				return err
+			}
+		}
+		// companionKeys starts with the creation time key, KEYS[2].
+		now := time.Now()
+		res, err := loadAndTouchScript.Run(ctx, c, append([]string{key}, companionKeys(key)...),
+			ttl.Milliseconds(), s.absTimeout.Milliseconds(), now.UnixNano()/int64(time.Millisecond), now.UnixNano()).Slice()
+		if err != nil {
+			return err
+		}
+		data, _ = res[0].(string)
+		touched, _ = res[1].(int64)
+		return nil
+	})
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if touched == 0 {
+		return false, ErrSessionExpired
+	}
+	span.SetAttributes(attribute.Int("session.size", len(data)))
+	if s.maxLoadSize != 0 && len(data) > s.maxLoadSize {
+		return false, ErrSessionTooBig
+	}
+	if err = s.deserialize([]byte(data), session); err != nil {
+		return false, err
+	}
+	if err = s.migrate(ctx, c, session); err != nil {
+		return false, err
+	}
+	session.IsNew = false
+	return true, s.recordActivity(ctx, session.ID)
+}
+
+// SaveIfAbsent stores the session only if no session with its ID exists,
+// using SET NX, e.g. to claim a one-time token ID. It reports whether the
+// session was created; an existing session is left untouched and reported
+// with created false and a nil error. An ID is generated if the session has
+// none. The TTL is derived from the session's MaxAge as by Save, but no cookie
+// is written.
+func (s *GoRediStore) SaveIfAbsent(ctx context.Context, session *sessions.Session) (created bool, err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "save", session)
+	defer func() { endSpan(span, err) }()
+	var size int
+	defer s.observeSave(time.Now(), &size, &err)
+	defer s.observe("save", time.Now(), &err)
+	defer s.lockValues(session)()
+	if s.validator != nil {
+		if err = s.validator(session); err != nil {
+			return false, err
+		}
+	}
+	ttl, err := s.ttl(session)
+	if err != nil {
+		return false, err
+	}
+	if err = s.ensureID(session); err != nil {
+		return false, err
+	}
+	b, err := s.serialize(ctx, session)
+	if err != nil {
+		return false, err
+	}
+	size = len(b)
+	span.SetAttributes(attribute.Int("session.size", len(b)))
+	key := s.sessionKey(session)
+	c := s.client(session)
+	err = s.guard(func() error {
+		if s.writeBehind != nil {
+			if err := s.writeBehind.flushKey(ctx, key); err != nil {
+				return err
+			}
+		}
+		created, err = c.SetNX(ctx, key, b, ttl).Result()
+		return err
+	})
+	if err != nil || !created {
+		return false, err
+	}
+	if s.trackCreatedAt {
+		err = c.SetNX(ctx, key+createdAtSuffix, time.Now().UnixNano(), ttl).Err()
+	}
+	return true, err
+}
+
+// Replace overwrites the stored values of the session with the given ID with
+// session's values, keeping the stored TTL, e.g. for admin tools that edit a
+// live session without changing when it expires. It uses SET XX KEEPTTL, so
+// the check and the write are atomic; companion keys are left as they are.
+// It returns ErrSessionNotFound if no session with the ID exists. Requires
+// Redis 6.0 or later.
+func (s *GoRediStore) Replace(ctx context.Context, id string, session *sessions.Session) (err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "save", session)
+	defer func() { endSpan(span, err) }()
+	var size int
+	defer s.observeSave(time.Now(), &size, &err)
+	defer s.observe("save", time.Now(), &err)
+	defer s.lockValues(session)()
+	b, err := s.serialize(ctx, session)
+	if err != nil {
+		return err
+	}
+	size = len(b)
+	span.SetAttributes(attribute.Int("session.size", len(b)))
+	key := s.key(id)
+	err = s.guard(func() error {
+		if s.writeBehind != nil {
+			if err := s.writeBehind.flushKey(ctx, key); err != nil {
+				return err
+			}
+		}
+		return s.Client.SetArgs(ctx, key, b, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	})
+	if err == redis.Nil {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+// loadFallback reads a session missing from this store from the fallback
+// store, if one is set, and writes it back into this store when found.
+func (s *GoRediStore) loadFallback(ctx context.Context, session *sessions.Session) (bool, error) {
+	if s.fallback == nil {
+		return false, nil
+	}
+	ok, err := s.fallback.load(ctx, session)
+	if err != nil || !ok {
+		return ok, err
+	}
+	ttl, err := s.ttl(session)
+	if err != nil {
+		return false, err
+	}
+	return true, s.save(ctx, session, ttl)
+}
+
+// delete removes keys from redis if MaxAge<0
+func (s *GoRediStore) delete(ctx context.Context, session *sessions.Session) (err error) {
+	defer s.track()()
+	ctx, span := s.startSpan(ctx, "delete", session)
+	defer func() { endSpan(span, err) }()
+	defer s.observe("delete", time.Now(), &err)
+	key := s.sessionKey(session)
+	if s.writeBehind != nil {
+		s.writeBehind.drop(key)
+	}
+	keys := append(companionKeys(key), key)
+	c := s.client(session)
+	// The activity entry is removed in the same MULTI/EXEC as the keys, so
+	// a failed delete leaves both in place. An activity set in another
+	// database, under SetDBSelector, is cleaned up afterwards.
+	indexed := s.trackActivity && c == s.Client
+	err = s.guard(func() error {
+		if s.deleteGrace <= 0 && !indexed {
+			return c.Del(ctx, keys...).Err()
+		}
+		pipelined := c.Pipelined
+		if indexed {
+			pipelined = c.TxPipelined
+		}
+		_, err := pipelined(ctx, func(pipe redis.Pipeliner) error {
+			if s.deleteGrace <= 0 {
+				pipe.Del(ctx, keys...)
+			} else {
+				for _, k := range keys {
+					pipe.PExpire(ctx, k, s.deleteGrace)
+				}
+			}
+			if indexed {
+				pipe.ZRem(ctx, s.activityKey(), s.keyID(session.ID))
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil || indexed {
+		return err
+	}
+	return s.forgetActivity(ctx, session.ID)
+}
+
+// guard runs fn, a round-trip to redis, through the circuit breaker if one
+// is set, and wraps a pool timeout in ErrPoolExhausted.
+func (s *GoRediStore) guard(fn func() error) error {
+	if s.breaker != nil {
+		if err := s.breaker.allow(); err != nil {
+			return err
+		}
+	}
+	err := fn()
+	if s.breaker != nil {
+		s.breaker.record(err)
+	}
+	if err != nil && isPoolTimeout(err) {
+		return fmt.Errorf("%w: %v", ErrPoolExhausted, err)
+	}
+	return err
+}
+
+// startSpan starts a span named "goredistore.<op>" for an operation on the
+// session. Without a tracer the returned span is a no-op.
+func (s *GoRediStore) startSpan(ctx context.Context, op string, session *sessions.Session) (context.Context, trace.Span) {
+	if s.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return s.tracer.Start(ctx, "goredistore."+op, trace.WithAttributes(
+		attribute.String("session.name", session.Name()),
+		attribute.String("session.key", s.sessionKey(session)),
+	))
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// requestContext returns the context of r, or a background context if r is
+// nil.
+func requestContext(r *http.Request) context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	return r.Context()
+}
+
+// scanCount is the COUNT hint passed to SCAN.
+const scanCount = 100
+
+// scan calls fn with each batch of session keys under the store's prefix.
+// Companion keys and the activity set are skipped. The iteration stops at
+// the first error; a failed SCAN is wrapped with the cursor it was issued at.
+func (s *GoRediStore) scan(ctx context.Context, fn func(keys []string) error) error {
+	c := s.Client
+	match := globEscape(s.prefix()) + "*"
+	activity := s.activityKey()
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keys, next, err := c.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return fmt.Errorf("goredistore: SCAN at cursor %d: %w", cursor, err)
+		}
+		n := 0
+		for _, key := range keys {
+			if !isCompanionKey(key) && key != activity {
+				keys[n] = key
+				n++
+			}
+		}
+		if n > 0 {
+			if err = fn(keys[:n]); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// isCompanionKey reports whether key holds auxiliary data of a session
+// rather than the session itself.
+func isCompanionKey(key string) bool {
+	for _, suffix := range companionSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// companionKeys returns the companion keys of the session stored at key.
+func companionKeys(key string) []string {
+	keys := make([]string, len(companionSuffixes))
+	for i, suffix := range companionSuffixes {
+		keys[i] = key + suffix
+	}
+	return keys
+}
+
+// globEscape escapes the glob metacharacters understood by SCAN MATCH.
+func globEscape(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// RedisKey returns the redis key the session is stored under, e.g. for
+// pasting into redis-cli. It does not contact redis.
+func (s *GoRediStore) RedisKey(session *sessions.Session) string {
+	return s.sessionKey(session)
+}
+
+// RedisKeyForID is like RedisKey for a bare session ID.
+func (s *GoRediStore) RedisKeyForID(id string) string {
+	return s.key(id)
+}
+
+// key returns the redis key holding the session with the given ID.
+func (s *GoRediStore) key(id string) string {
+	return s.prefix() + s.keyID(id)
+}
+
+// sessionKey returns the redis key holding the session: the key builder's if
+// one is set, else key(session.ID).
+func (s *GoRediStore) sessionKey(session *sessions.Session) string {
+	if s.keyBuilder != nil {
+		return s.keyBuilder(session)
+	}
+	return s.key(session.ID)
+}
+
+// keyID returns the part of the session's redis key after the prefix: the
+// ID itself or, with key hashing, its SHA-256 in hex after any hash tag.
+func (s *GoRediStore) keyID(id string) string {
+	if !s.keyHashing {
+		return id
+	}
+	tag := ""
+	if strings.HasPrefix(id, "{") {
+		if end := strings.IndexByte(id, '}'); end > 0 {
+			tag, id = id[:end+1], id[end+1:]
+		}
+	}
+	sum := sha256.Sum256([]byte(id))
+	return tag + hex.EncodeToString(sum[:])
+}
@@ -2,15 +2,32 @@ package goredistore
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const (
@@ -318,13 +335,13 @@ func TestGoRediStore(t *testing.T) {
 
 		session, err = store.New(req, "my session")
 		session.Values["big"] = make([]byte, base64.StdEncoding.DecodedLen(4096*2))
-		err = sessions.Save(req, w)
+		err = session.Save(req, w)
 		if err == nil {
 			t.Fatal("expected an error, got nil")
 		}
 
 		store.SetMaxLength(4096 * 3) // A bit more than the value size to account for encoding overhead.
-		err = sessions.Save(req, w)
+		err = session.Save(req, w)
 		if err != nil {
 			t.Fatal("failed to Save:", err)
 		}
@@ -464,3 +481,3302 @@ func ExampleGoRediStore() {
 func init() {
 	gob.Register(FlashMessage{})
 }
+
+func TestCreatedAt(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetTrackCreatedAt(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := store.Get(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = sessions.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	created, err := store.CreatedAt(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("Error reading creation time: %v", err)
+	}
+	if created.IsZero() || time.Since(created) > time.Minute {
+		t.Fatalf("Unexpected creation time %v", created)
+	}
+
+	// Subsequent saves of the loaded session must keep the original time.
+	for i := 0; i < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+		rsp = NewRecorder()
+		if session, err = store.Get(req, "session-key"); err != nil {
+			t.Fatalf("Error getting session: %v", err)
+		}
+		if session.IsNew {
+			t.Fatal("Expected session to be loaded from redis")
+		}
+		session.Values["foo"] = i
+		if err = sessions.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		got, err := store.CreatedAt(context.Background(), session.ID)
+		if err != nil {
+			t.Fatalf("Error reading creation time: %v", err)
+		}
+		if !got.Equal(created) {
+			t.Errorf("Expected creation time %v; Got %v", created, got)
+		}
+	}
+
+	if _, err = store.CreatedAt(context.Background(), "missing"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, d := range []time.Duration{time.Minute, 90 * 24 * time.Hour, 1500 * time.Millisecond} {
+		if err = store.SetTTL(ctx, session.ID, d); err != nil {
+			t.Fatalf("Error setting TTL: %v", err)
+		}
+		ttl, err := store.Client.PTTL(ctx, store.key(session.ID)).Result()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if ttl <= 0 || ttl > d {
+			t.Errorf("Expected TTL of at most %v; Got %v", d, ttl)
+		}
+	}
+
+	if err = store.SetTTL(ctx, "missing", time.Minute); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+
+	for _, d := range []time.Duration{0, -time.Second} {
+		if err = store.SetTTL(ctx, session.ID, d); !errors.Is(err, ErrInvalidTTL) {
+			t.Errorf("Expected ErrInvalidTTL for %v; Got %v", d, err)
+		}
+	}
+	if ok, _ := store.Exists(ctx, session.ID); !ok {
+		t.Error("Expected an invalid TTL to leave the session alone")
+	}
+}
+
+func TestSaveMerge(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["a"] = "a"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// Two requests load the same session.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	tabA, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	tabB, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+
+	// B writes first, then A merges its own change on top.
+	tabB.Values["b"] = "b"
+	if err = store.Save(req, NewRecorder(), tabB); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	tabA.Values["a"] = "A"
+	if err = store.SaveMerge(req, NewRecorder(), tabA); err != nil {
+		t.Fatalf("Error merging session: %v", err)
+	}
+
+	loaded, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	if loaded.Values["a"] != "A" {
+		t.Errorf("Expected a=A; Got %v", loaded.Values["a"])
+	}
+	if loaded.Values["b"] != "b" {
+		t.Errorf("Expected interleaved b=b to survive; Got %v", loaded.Values["b"])
+	}
+	if tabA.Values["b"] != "b" {
+		t.Errorf("Expected merged values on session; Got %v", tabA.Values)
+	}
+}
+
+func TestSaveMergeKeepsConcurrentChanges(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["a"] = "a"
+	session.Values["c"] = "c"
+	session.Values["d"] = "d"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(context.Background(), store.key(session.ID))
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	tabA, _ := store.New(req, "session-key")
+	tabB, _ := store.New(req, "session-key")
+
+	// B changes a key A also loaded; A only changes c and deletes d.
+	tabB.Values["a"] = "B"
+	if err = store.Save(req, NewRecorder(), tabB); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	tabA.Values["c"] = "C"
+	delete(tabA.Values, "d")
+	if err = store.SaveMerge(req, NewRecorder(), tabA); err != nil {
+		t.Fatalf("Error merging session: %v", err)
+	}
+
+	loaded, _ := store.New(req, "session-key")
+	if loaded.Values["a"] != "B" {
+		t.Errorf("Expected B's a=B to survive; Got %v", loaded.Values["a"])
+	}
+	if loaded.Values["c"] != "C" {
+		t.Errorf("Expected c=C; Got %v", loaded.Values["c"])
+	}
+	if _, ok := loaded.Values["d"]; ok {
+		t.Errorf("Expected d to be deleted; Got %v", loaded.Values)
+	}
+}
+
+func TestJSONSerializerInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+	}{
+		{"string", "k", "ok\xffnot"},
+		{"key", "k\xfe", "ok"},
+		{"slice", "k", []interface{}{"ok", "\xc3\x28"}},
+		{"map", "k", map[string]interface{}{"nested": "\xa0\xa1"}},
+	}
+	for _, tt := range tests {
+		ss := sessions.NewSession(nil, "session-key")
+		ss.Values[tt.key] = tt.value
+		if _, err := (JSONSerializer{}).Serialize(ss); err == nil {
+			t.Errorf("%s: expected an error for invalid UTF-8, got nil", tt.name)
+		}
+	}
+
+	ss := sessions.NewSession(nil, "session-key")
+	ss.Values["k"] = "héllo"
+	if _, err := (JSONSerializer{}).Serialize(ss); err != nil {
+		t.Errorf("Unexpected error for valid UTF-8: %v", err)
+	}
+}
+
+func TestSessionsStoreInterface(t *testing.T) {
+	rs, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer rs.Close()
+	var store sessions.Store = rs
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	if !session.IsNew {
+		t.Error("Expected a new session")
+	}
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, rsp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookies := rsp.Header()["Set-Cookie"]
+	if len(cookies) != 1 {
+		t.Fatalf("No cookies. Header: %s", rsp.Header())
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	if session, err = store.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	if session.IsNew {
+		t.Error("Expected a loaded session")
+	}
+	if session.Values["foo"] != "bar" {
+		t.Errorf("Expected foo=bar; Got %v", session.Values["foo"])
+	}
+	if s, _ := store.Get(req, "session-key"); s != session {
+		t.Error("Expected Get to return the registry cached session")
+	}
+}
+
+func TestMaxLoadSize(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// Poison the stored value with an oversized payload.
+	if err = store.Client.Set(ctx, store.key(session.ID), make([]byte, 2048), time.Minute).Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	store.SetMaxLoadSize(1024)
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	if _, err = store.New(req, "session-key"); err != ErrSessionTooBig {
+		t.Errorf("Expected ErrSessionTooBig; Got %v", err)
+	}
+}
+
+func TestReap(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix(fmt.Sprintf("reap_%d_", time.Now().UnixNano()))
+	store.SetTrackCreatedAt(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for i := 0; i < 3; i++ {
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error creating session: %v", err)
+		}
+		session.Values["i"] = i
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	// A key that never got a TTL.
+	if err = store.Client.Set(ctx, store.key("noexpiry"), "x", 0).Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	scanned, expired, err := store.Reap(ctx)
+	if err != nil {
+		t.Fatalf("Error reaping: %v", err)
+	}
+	if scanned != 4 || expired != 1 {
+		t.Errorf("Expected 4 scanned, 1 expired; Got %d, %d", scanned, expired)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key("noexpiry")).Result(); n != 1 {
+		t.Error("Expected Reap to only report by default")
+	}
+
+	store.SetReapDeletes(true)
+	if _, expired, err = store.Reap(ctx); err != nil || expired != 1 {
+		t.Fatalf("Expected 1 expired; Got %d, %v", expired, err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key("noexpiry")).Result(); n != 0 {
+		t.Error("Expected stale key to be deleted")
+	}
+	if scanned, expired, err = store.Reap(ctx); scanned != 3 || expired != 0 || err != nil {
+		t.Errorf("Expected 3 scanned, 0 expired; Got %d, %d, %v", scanned, expired, err)
+	}
+}
+
+func TestWithSerializer(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")}, WithSerializer(JSONSerializer{}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	data, err := store.Client.Get(ctx, store.key(session.ID)).Result()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if data != `{"foo":"bar"}` {
+		t.Errorf("Expected JSON payload; Got %q", data)
+	}
+}
+
+// blockingSerializer blocks Serialize until release is closed.
+type blockingSerializer struct {
+	GobSerializer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	close(s.entered)
+	<-s.release
+	return s.GobSerializer.Serialize(ss)
+}
+
+func TestCloseContext(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ser := blockingSerializer{entered: make(chan struct{}), release: make(chan struct{})}
+	store.SetSerializer(ser)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	saved := make(chan error, 1)
+	go func() { saved <- session.Save(req, NewRecorder()) }()
+	<-ser.entered
+
+	closed := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		closed <- store.CloseContext(ctx)
+	}()
+	select {
+	case err = <-closed:
+		t.Fatalf("CloseContext returned before in-flight save finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(ser.release)
+	if err = <-saved; err != nil {
+		t.Errorf("Expected in-flight save to succeed; Got %v", err)
+	}
+	if err = <-closed; err != nil {
+		t.Errorf("Expected clean close; Got %v", err)
+	}
+	if err = store.Client.Ping(context.Background()).Err(); err == nil {
+		t.Error("Expected client to be closed")
+	}
+}
+
+func TestCloseContextDeadline(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.track()()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = store.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded; Got %v", err)
+	}
+}
+
+func TestJSONSerializerTypedValues(t *testing.T) {
+	now := time.Now().Round(0)
+	in := sessions.NewSession(nil, "session-key")
+	in.Values["time"] = now
+	in.Values["bytes"] = []byte{0, 1, 0xff}
+	in.Values["nested"] = []interface{}{now, map[string]interface{}{"b": []byte("x")}}
+	in.Values["string"] = "2019-01-01T00:00:00Z"
+
+	b, err := JSONSerializer{}.Serialize(in)
+	if err != nil {
+		t.Fatalf("Error serializing: %v", err)
+	}
+	out := sessions.NewSession(nil, "session-key")
+	if err = (JSONSerializer{}).Deserialize(b, out); err != nil {
+		t.Fatalf("Error deserializing: %v", err)
+	}
+
+	if got, ok := out.Values["time"].(time.Time); !ok || !got.Equal(now) {
+		t.Errorf("Expected time %v; Got %#v", now, out.Values["time"])
+	}
+	if got, ok := out.Values["bytes"].([]byte); !ok || !bytes.Equal(got, []byte{0, 1, 0xff}) {
+		t.Errorf("Expected bytes; Got %#v", out.Values["bytes"])
+	}
+	nested, ok := out.Values["nested"].([]interface{})
+	if !ok || len(nested) != 2 {
+		t.Fatalf("Expected nested slice; Got %#v", out.Values["nested"])
+	}
+	if got, ok := nested[0].(time.Time); !ok || !got.Equal(now) {
+		t.Errorf("Expected nested time; Got %#v", nested[0])
+	}
+	if m, ok := nested[1].(map[string]interface{}); !ok || !bytes.Equal(m["b"].([]byte), []byte("x")) {
+		t.Errorf("Expected nested bytes; Got %#v", nested[1])
+	}
+	if out.Values["string"] != "2019-01-01T00:00:00Z" {
+		t.Errorf("Expected plain strings to stay strings; Got %#v", out.Values["string"])
+	}
+}
+
+func TestJSONSerializerHintCollision(t *testing.T) {
+	in := sessions.NewSession(nil, "session-key")
+	in.Values["time"] = map[string]interface{}{"$time": "not a time"}
+	in.Values["bytes"] = map[string]string{"$bytes": "not base64!"}
+	in.Values["escaped"] = map[string]interface{}{"$$time": "x", "$other": "y"}
+
+	b, err := JSONSerializer{}.Serialize(in)
+	if err != nil {
+		t.Fatalf("Error serializing: %v", err)
+	}
+	out := sessions.NewSession(nil, "session-key")
+	if err = (JSONSerializer{}).Deserialize(b, out); err != nil {
+		t.Fatalf("Error deserializing: %v", err)
+	}
+	want := map[interface{}]interface{}{
+		"time":    map[string]interface{}{"$time": "not a time"},
+		"bytes":   map[string]interface{}{"$bytes": "not base64!"},
+		"escaped": map[string]interface{}{"$$time": "x", "$other": "y"},
+	}
+	if !reflect.DeepEqual(out.Values, want) {
+		t.Errorf("Expected colliding maps to round-trip; Got %#v", out.Values)
+	}
+}
+
+func TestPruneEmpty(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetPruneEmpty(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 1 {
+		t.Fatal("Expected session to be stored")
+	}
+
+	delete(session.Values, "foo")
+	rsp := httptest.NewRecorder()
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 0 {
+		t.Error("Expected empty session to be pruned")
+	}
+	cookies := rsp.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected an expired cookie; Got %v", cookies)
+	}
+}
+
+func TestValidator(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	errNoUser := errors.New("missing user")
+	store.SetValidator(func(s *sessions.Session) error {
+		if _, ok := s.Values["user"]; !ok {
+			return errNoUser
+		}
+		return nil
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.ID = "validator-rejected"
+	session.Values["foo"] = "bar"
+	rsp := NewRecorder()
+	if err = session.Save(req, rsp); err != errNoUser {
+		t.Fatalf("Expected validator error; Got %v", err)
+	}
+	if _, ok := rsp.Header()["Set-Cookie"]; ok {
+		t.Error("Expected no cookie for a rejected session")
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 0 {
+		t.Error("Expected no redis write for a rejected session")
+	}
+
+	session.Values["user"] = "bob"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 1 {
+		t.Error("Expected valid session to be stored")
+	}
+	store.Client.Del(ctx, store.key(session.ID))
+}
+
+func TestPreloadByIDs(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error creating session: %v", err)
+		}
+		session.Values["i"] = i
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		ids = append(ids, session.ID)
+	}
+	ids = append(ids, "absent-1", "absent-2")
+
+	loaded, err := store.PreloadByIDs(context.Background(), "session-key", ids)
+	if err != nil {
+		t.Fatalf("Error preloading sessions: %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("Expected 3 sessions; Got %d", len(loaded))
+	}
+	for i, id := range ids[:3] {
+		session, ok := loaded[id]
+		if !ok {
+			t.Fatalf("Expected session %s to be loaded", id)
+		}
+		if session.ID != id || session.IsNew || session.Values["i"] != i {
+			t.Errorf("Unexpected session %#v", session)
+		}
+	}
+	if _, ok := loaded["absent-1"]; ok {
+		t.Error("Expected absent IDs to be omitted")
+	}
+}
+
+func TestKeepIDPadding(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	for _, keep := range []bool{false, true} {
+		store.SetKeepIDPadding(keep)
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error creating session: %v", err)
+		}
+		session.Values["foo"] = "bar"
+		rsp := NewRecorder()
+		if err = session.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		if padded := strings.HasSuffix(session.ID, "="); padded != keep {
+			t.Errorf("keep=%v: unexpected ID %q", keep, session.ID)
+		}
+
+		req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+		loaded, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		if loaded.ID != session.ID || loaded.Values["foo"] != "bar" {
+			t.Errorf("keep=%v: expected session %q to round-trip; Got %q", keep, session.ID, loaded.ID)
+		}
+		loaded.Options.MaxAge = -1
+		if err = loaded.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error deleting session: %v", err)
+		}
+		if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 0 {
+			t.Errorf("keep=%v: expected session to be deleted", keep)
+		}
+	}
+}
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	addr := setup()
+	old, err := NewGoRediStoreWithDB(10, "tcp", addr, "", 2, []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer old.Close()
+	store, err := NewGoRediStore(10, "tcp", addr, "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetFallbackStore(old)
+
+	// The session only exists on the old instance.
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := old.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Fatalf("Expected session from fallback store; Got %#v", loaded)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 1 {
+		t.Error("Expected fallback hit to be written back to the primary")
+	}
+
+	// A miss on both stays a new session.
+	store.Client.Del(ctx, store.key(session.ID))
+	old.Client.Del(ctx, old.key(session.ID))
+	if loaded, err = store.New(req, "session-key"); err != nil || !loaded.IsNew {
+		t.Errorf("Expected a new session; Got %v, %v", loaded.IsNew, err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	want := StoreConfig{
+		KeyPrefix:      "session_",
+		MaxLength:      4096,
+		DefaultMaxAge:  1200,
+		CookieMaxAge:   sessionExpire,
+		SerializerName: "goredistore.GobSerializer",
+	}
+	if got := store.Config(); got != want {
+		t.Errorf("Expected %#v; Got %#v", want, got)
+	}
+
+	store.SetKeyPrefix("app_")
+	store.SetMaxLength(8192)
+	store.SetMaxAge(3600)
+	store.SetSerializer(JSONSerializer{})
+	store.DefaultMaxAge = 60
+	want = StoreConfig{
+		KeyPrefix:      "app_",
+		MaxLength:      8192,
+		DefaultMaxAge:  60,
+		CookieMaxAge:   3600,
+		SerializerName: "goredistore.JSONSerializer",
+	}
+	if got := store.Config(); got != want {
+		t.Errorf("Expected %#v; Got %#v", want, got)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store.SetTracer(provider.Tracer("test"))
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	if session, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	session.Options.MaxAge = -1
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans; Got %d", len(spans))
+	}
+	for i, name := range []string{"goredistore.save", "goredistore.load", "goredistore.delete"} {
+		span := spans[i]
+		if span.Name() != name {
+			t.Errorf("Expected span %s; Got %s", name, span.Name())
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		if attrs["session.name"].AsString() != "session-key" {
+			t.Errorf("%s: unexpected session.name %v", name, attrs["session.name"])
+		}
+		if attrs["session.key"].AsString() != store.key(session.ID) {
+			t.Errorf("%s: unexpected session.key %v", name, attrs["session.key"])
+		}
+		if _, ok := attrs["session.size"]; !ok && name != "goredistore.delete" {
+			t.Errorf("%s: expected session.size attribute", name)
+		}
+	}
+
+	// Errors are recorded on the span.
+	store.SetMaxLength(1)
+	session, _ = store.New(req, "other")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != ErrSessionTooBig {
+		t.Fatalf("Expected ErrSessionTooBig; Got %v", err)
+	}
+	spans = recorder.Ended()
+	if span := spans[len(spans)-1]; span.Status().Code != codes.Error || len(span.Events()) == 0 {
+		t.Errorf("Expected error to be recorded; Got %v", span.Status())
+	}
+}
+
+func TestCookieless(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetCookieless(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if cookies, ok := rsp.Header()["Set-Cookie"]; ok {
+		t.Errorf("Expected no cookies; Got %v", cookies)
+	}
+	if session.ID == "" {
+		t.Fatal("Expected an ID to be generated")
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 1 {
+		t.Error("Expected session to be stored")
+	}
+
+	session.Options.MaxAge = -1
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if cookies, ok := rsp.Header()["Set-Cookie"]; ok {
+		t.Errorf("Expected no cookies on delete; Got %v", cookies)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetFingerprint(func(r *http.Request) string { return r.UserAgent() })
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Set("User-Agent", "browser/1")
+	rsp := NewRecorder()
+	session, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := rsp.Header()["Set-Cookie"][0]
+
+	// Same fingerprint loads the session.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Set("User-Agent", "browser/1")
+	req.Header.Add("Cookie", cookie)
+	loaded, err := store.New(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected matching fingerprint to load the session; Got %#v", loaded)
+	}
+
+	// A different fingerprint gets a fresh session.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Set("User-Agent", "curl/7")
+	req.Header.Add("Cookie", cookie)
+	if loaded, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if !loaded.IsNew || loaded.ID != "" || len(loaded.Values) != 0 {
+		t.Errorf("Expected a fresh session on fingerprint mismatch; Got %#v", loaded)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 1 {
+		t.Error("Expected the original session to be kept")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix(fmt.Sprintf("rewrite_%d_", time.Now().UnixNano()))
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error creating session: %v", err)
+		}
+		session.Values["i"] = i
+		session.Options.MaxAge = 100 * (i + 1)
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		ids = append(ids, session.ID)
+	}
+	original, _ := store.Client.Get(ctx, store.key(ids[0])).Bytes()
+
+	n, err := store.Rewrite(ctx, func(old []byte) ([]byte, error) { return old, nil })
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 no-op rewrites; Got %d, %v", n, err)
+	}
+	if got, _ := store.Client.Get(ctx, store.key(ids[0])).Bytes(); !bytes.Equal(got, original) {
+		t.Error("Expected no-op rewrite to keep the value")
+	}
+
+	n, err = store.Rewrite(ctx, func(old []byte) ([]byte, error) {
+		return append([]byte("v2:"), old...), nil
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 rewrites; Got %d, %v", n, err)
+	}
+	for i, id := range ids {
+		got, _ := store.Client.Get(ctx, store.key(id)).Bytes()
+		if !bytes.HasPrefix(got, []byte("v2:")) {
+			t.Errorf("Expected rewritten value; Got %q", got)
+		}
+		ttl, _ := store.Client.TTL(ctx, store.key(id)).Result()
+		if want := time.Duration(100*(i+1)) * time.Second; ttl <= want-5*time.Second || ttl > want {
+			t.Errorf("Expected TTL near %v to be preserved; Got %v", want, ttl)
+		}
+	}
+}
+
+func TestSetCookieMaxLength(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("hash-key-1"), nil, []byte("hash-key-2"), nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	if len(store.Codecs) != 2 {
+		t.Fatalf("Expected 2 codecs; Got %d", len(store.Codecs))
+	}
+
+	store.SetCookieMaxLength(16)
+	for i, codec := range store.Codecs {
+		if _, err = codec.Encode("session-key", "some-session-id"); err == nil {
+			t.Errorf("Codec %d: expected encoded value to exceed the max length", i)
+		}
+	}
+
+	store.SetCookieMaxLength(0)
+	for i, codec := range store.Codecs {
+		if _, err = codec.Encode("session-key", "some-session-id"); err != nil {
+			t.Errorf("Codec %d: expected no length limit; Got %v", i, err)
+		}
+	}
+}
+
+func TestVerifyCookie(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	if _, err = store.VerifyCookie(req, "session-key"); err != http.ErrNoCookie {
+		t.Errorf("Expected http.ErrNoCookie; Got %v", err)
+	}
+
+	rsp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := rsp.Result().Cookies()[0]
+
+	// Valid cookie, even with redis data gone.
+	store.Client.Del(ctx, store.key(session.ID))
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.AddCookie(cookie)
+	if id, err := store.VerifyCookie(req, "session-key"); err != nil || id != session.ID {
+		t.Errorf("Expected ID %q; Got %q, %v", session.ID, id, err)
+	}
+
+	// Tampered cookie.
+	tampered := *cookie
+	tampered.Value = cookie.Value[:len(cookie.Value)-4] + "AAAA"
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.AddCookie(&tampered)
+	if _, err = store.VerifyCookie(req, "session-key"); err == nil {
+		t.Error("Expected an error for a tampered cookie")
+	}
+
+	// Expired cookie.
+	store.SetMaxAge(1)
+	time.Sleep(2100 * time.Millisecond)
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.AddCookie(cookie)
+	if _, err = store.VerifyCookie(req, "session-key"); err == nil {
+		t.Error("Expected an error for an expired cookie")
+	}
+}
+
+func TestRedisKey(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix("app_session_")
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	key := store.RedisKey(session)
+	if key != "app_session_"+session.ID {
+		t.Errorf("Unexpected key %q", key)
+	}
+	if got := store.RedisKeyForID(session.ID); got != key {
+		t.Errorf("Expected %q; Got %q", key, got)
+	}
+	if n, _ := store.Client.Exists(ctx, key).Result(); n != 1 {
+		t.Errorf("Expected save to write %q", key)
+	}
+}
+
+func TestSaveWithTTL(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for _, ttl := range []time.Duration{500 * time.Millisecond, 30 * time.Second} {
+		session, _ := store.New(req, "session-key")
+		session.Values["foo"] = "bar"
+		if err = store.SaveWithTTL(req, NewRecorder(), session, ttl); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		got, err := store.Client.PTTL(ctx, store.key(session.ID)).Result()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got <= 0 || got > ttl {
+			t.Errorf("Expected TTL of at most %v; Got %v", ttl, got)
+		}
+	}
+
+	session, _ := store.New(req, "session-key")
+	if err = store.SaveWithTTL(req, NewRecorder(), session, 0); err == nil {
+		t.Error("Expected an error for a zero TTL")
+	}
+}
+
+func TestMoveToPrefix(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix("anon_")
+	store.SetTrackCreatedAt(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	session.Options.MaxAge = 300
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	ctx := context.Background()
+	if err = store.MoveToPrefix(ctx, session.ID, "auth_"); err != nil {
+		t.Fatalf("Error moving session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 0 {
+		t.Error("Expected the old key to be gone")
+	}
+
+	authed, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer authed.Close()
+	authed.SetKeyPrefix("auth_")
+	loaded, err := authed.PreloadByIDs(ctx, "session-key", []string{session.ID})
+	if err != nil || loaded[session.ID] == nil || loaded[session.ID].Values["foo"] != "bar" {
+		t.Fatalf("Expected the value to survive the move; Got %v, %v", loaded, err)
+	}
+	if ttl, _ := authed.Client.TTL(ctx, authed.key(session.ID)).Result(); ttl <= 290*time.Second || ttl > 300*time.Second {
+		t.Errorf("Expected the TTL to survive the move; Got %v", ttl)
+	}
+	if _, err = authed.CreatedAt(ctx, session.ID); err != nil {
+		t.Errorf("Expected companion keys to move; Got %v", err)
+	}
+
+	if err = store.MoveToPrefix(ctx, session.ID, "auth_"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestTTLAndExists(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.Client.Set(ctx, store.key("ttl-live"), "x", time.Minute)
+	store.Client.Set(ctx, store.key("ttl-noexpiry"), "x", 0)
+	store.Client.Del(ctx, store.key("ttl-missing"))
+	defer store.Client.Del(ctx, store.key("ttl-live"), store.key("ttl-noexpiry"))
+
+	tests := []struct {
+		id     string
+		exists bool
+		ttl    time.Duration
+		err    error
+	}{
+		{"ttl-missing", false, 0, ErrSessionNotFound},
+		{"ttl-noexpiry", true, NoExpiry, nil},
+		{"ttl-live", true, time.Minute, nil},
+	}
+	for _, tt := range tests {
+		ttl, err := store.TTL(ctx, tt.id)
+		if err != tt.err {
+			t.Errorf("%s: expected error %v; Got %v", tt.id, tt.err, err)
+		}
+		if tt.ttl > 0 && (ttl <= 0 || ttl > tt.ttl) || tt.ttl <= 0 && ttl != tt.ttl {
+			t.Errorf("%s: expected TTL %v; Got %v", tt.id, tt.ttl, ttl)
+		}
+		exists, err := store.Exists(ctx, tt.id)
+		if err != nil || exists != tt.exists {
+			t.Errorf("%s: expected exists=%v; Got %v, %v", tt.id, tt.exists, exists, err)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	auth := store.Derive("auth_")
+	cart := store.Derive("cart_")
+	cart.SetSerializer(JSONSerializer{})
+	cart.Options.Path = "/cart"
+
+	if auth.Client != store.Client || cart.Client != store.Client {
+		t.Fatal("Expected derived stores to share the client")
+	}
+	if store.Options.Path != "/" || auth.Options.Path != "/" {
+		t.Error("Expected Options to be independent")
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	a, _ := auth.New(req, "auth")
+	a.Values["user"] = "bob"
+	if err = a.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	c, _ := cart.New(req, "cart")
+	c.Values["items"] = "3"
+	if err = c.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	if ok, _ := auth.Exists(context.Background(), a.ID); !ok {
+		t.Error("Expected auth session under auth_ prefix")
+	}
+	if ok, _ := cart.Exists(context.Background(), a.ID); ok {
+		t.Error("Expected stores to use separate prefixes")
+	}
+	raw, _ := store.Client.Get(ctx, cart.key(c.ID)).Result()
+	if raw != `{"items":"3"}` {
+		t.Errorf("Expected cart store to use JSON; Got %q", raw)
+	}
+	if _, ok := store.serializer.(GobSerializer); !ok {
+		t.Error("Expected parent serializer to be unchanged")
+	}
+
+	// Closing a derived store keeps the shared pool alive.
+	if err = cart.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = auth.Client.Ping(ctx).Err(); err != nil {
+		t.Errorf("Expected shared client to stay open; Got %v", err)
+	}
+}
+
+func TestValueKeys(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for _, ser := range []SessionSerializer{GobSerializer{}, JSONSerializer{}} {
+		store.SetSerializer(ser)
+		session, _ := store.New(req, "session-key")
+		session.Values["user"] = "bob"
+		session.Values["cart"] = "3"
+		session.Values["seen"] = true
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		keys, err := store.ValueKeys(ctx, session.ID)
+		if err != nil {
+			t.Fatalf("%T: error listing keys: %v", ser, err)
+		}
+		if fmt.Sprint(keys) != "[cart seen user]" {
+			t.Errorf("%T: unexpected keys %v", ser, keys)
+		}
+	}
+
+	if _, err = store.ValueKeys(ctx, "missing"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	session.Options.MaxAge = math.MaxInt32 + 1
+	rsp := NewRecorder()
+	if err = session.Save(req, rsp); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("Expected ErrInvalidTTL; Got %v", err)
+	}
+	if _, ok := rsp.Header()["Set-Cookie"]; ok {
+		t.Error("Expected no cookie for a rejected session")
+	}
+	if err = store.SaveWithTTL(req, rsp, session, 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Expected ErrInvalidTTL for a zero TTL; Got %v", err)
+	}
+
+	session.Options.MaxAge = math.MaxInt32
+	if err = session.Save(req, rsp); err != nil {
+		t.Errorf("Expected the largest TTL to be accepted; Got %v", err)
+	}
+	store.Client.Del(ctx, store.key(session.ID))
+}
+
+func TestHashTag(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix("tagged_")
+	store.SetHashTag(func(s *sessions.Session) string {
+		user, _ := s.Values["user"].(string)
+		return user
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	var keys []string
+	for _, user := range []string{"al{ic}e", "alice", ""} {
+		session, _ := store.New(req, "session-key")
+		session.Values["user"] = user
+		rsp := NewRecorder()
+		if err = session.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		key := store.RedisKey(session)
+		defer store.Client.Del(ctx, key)
+		keys = append(keys, key)
+
+		// The tagged session loads from its cookie like any other.
+		req2, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req2.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+		loaded, err := store.New(req2, "session-key")
+		if err != nil || loaded.IsNew {
+			t.Fatalf("Error loading tagged session: %v", err)
+		}
+		if loaded.Values["user"] != user {
+			t.Errorf("Expected user %q; Got %v", user, loaded.Values["user"])
+		}
+	}
+
+	for _, key := range keys[:2] {
+		if !strings.HasPrefix(key, "tagged_{alice}") {
+			t.Errorf("Expected key of the form tagged_{alice}ID; Got %q", key)
+		}
+	}
+	if strings.Contains(keys[2], "{") {
+		t.Errorf("Expected an empty tag to generate an untagged key; Got %q", keys[2])
+	}
+	values, err := store.Client.MGet(ctx, keys[:2]...).Result()
+	if err != nil {
+		t.Fatalf("Error reading tagged sessions: %v", err)
+	}
+	for i, v := range values {
+		if v == nil {
+			t.Errorf("Expected %q to be stored", keys[i])
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	prefix := fmt.Sprintf("flush_%d_", time.Now().UnixNano())
+	store.SetKeyPrefix(prefix)
+	store.SetTrackCreatedAt(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for i := 0; i < 3; i++ {
+		session, _ := store.New(req, "session-key")
+		session.Values["i"] = i
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	other := "keep_" + prefix
+	store.Client.Set(ctx, other, "x", time.Minute)
+	defer store.Client.Del(ctx, other)
+
+	if _, err = store.Flush(context.Background()); err != nil {
+		t.Fatalf("Error flushing store: %v", err)
+	}
+	if keys, _ := store.Client.Keys(ctx, globEscape(prefix)+"*").Result(); len(keys) != 0 {
+		t.Errorf("Expected no keys under the prefix; Got %v", keys)
+	}
+	if n, _ := store.Client.Exists(ctx, other).Result(); n != 1 {
+		t.Errorf("Expected Flush to keep %q", other)
+	}
+
+	store.SetKeyPrefix("")
+	if _, err = store.Flush(context.Background()); err != ErrNoKeyPrefix {
+		t.Errorf("Expected ErrNoKeyPrefix; Got %v", err)
+	}
+}
+
+func TestCompactCookie(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	cookie := func() (string, string) {
+		session, _ := store.New(req, "session-key")
+		session.Values["foo"] = "bar"
+		rsp := NewRecorder()
+		if err := session.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		store.Client.Del(ctx, store.key(session.ID))
+		return session.ID, rsp.HeaderMap["Set-Cookie"][0]
+	}
+
+	_, full := cookie()
+	store.SetCompactCookie(true)
+	id, compact := cookie()
+	if len(id) != 26 {
+		t.Errorf("Expected a 26 character ID; Got %q", id)
+	}
+	if len(compact) >= len(full) {
+		t.Errorf("Expected a smaller cookie; Got %d bytes, default %d", len(compact), len(full))
+	}
+}
+
+func TestTTLFunc(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetTTLFunc(func(s *sessions.Session) int {
+		switch s.Values["role"] {
+		case "admin":
+			return 600
+		case "remember":
+			return 86400 * 30
+		}
+		return 0
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for role, want := range map[string]time.Duration{
+		"admin":    600 * time.Second,
+		"remember": 30 * 24 * time.Hour,
+		"user":     time.Duration(store.Options.MaxAge) * time.Second,
+	} {
+		session, _ := store.New(req, "session-key")
+		session.Values["role"] = role
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		ttl, err := store.Client.PTTL(ctx, store.key(session.ID)).Result()
+		if err != nil {
+			t.Fatalf("Error reading TTL: %v", err)
+		}
+		if ttl <= want-time.Second || ttl > want {
+			t.Errorf("Expected %s TTL near %v; Got %v", role, want, ttl)
+		}
+		store.Client.Del(ctx, store.key(session.ID))
+	}
+}
+
+func TestSaveNilResponseWriter(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, nil, session); err != nil {
+		t.Fatalf("Error saving session without a response: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 1 {
+		t.Errorf("Expected the session to be persisted")
+	}
+
+	session.Options.MaxAge = -1
+	if err = store.Save(req, nil, session); err != nil {
+		t.Fatalf("Error deleting session without a response: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 0 {
+		t.Errorf("Expected the session to be deleted")
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{
+		Addr:        setup(),
+		PoolSize:    1,
+		PoolTimeout: 50 * time.Millisecond,
+	})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	// Hold the only connection in a WATCH until the saves are done.
+	held, release := make(chan struct{}), make(chan struct{})
+	go client.Watch(ctx, func(*redis.Tx) error {
+		close(held)
+		<-release
+		return nil
+	}, "pool_exhausted")
+	<-held
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			session, _ := store.New(req, "session-key")
+			session.Values["foo"] = "bar"
+			errs <- session.Save(req, NewRecorder())
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; !errors.Is(err, ErrPoolExhausted) {
+			t.Errorf("Expected ErrPoolExhausted; Got %v", err)
+		}
+	}
+}
+
+func TestMultiSerializer(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	// A legacy session written before the switch, as untagged Gob.
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	legacy, _ := store.New(req, "session-key")
+	legacy.Values["format"] = "gob"
+	if err = legacy.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving legacy session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(legacy.ID))
+
+	store.SetSerializer(MultiSerializer{Preferred: "json"})
+	fresh, _ := store.New(req, "session-key")
+	fresh.Values["format"] = "json"
+	if err = fresh.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving tagged session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(fresh.ID))
+
+	data, _ := store.Client.Get(ctx, store.key(fresh.ID)).Bytes()
+	if !bytes.HasPrefix(data, []byte("\x00json\x00{")) {
+		t.Errorf("Expected tagged JSON; Got %q", data)
+	}
+
+	loaded, err := store.PreloadByIDs(context.Background(), "session-key", []string{legacy.ID, fresh.ID})
+	if err != nil {
+		t.Fatalf("Error loading sessions: %v", err)
+	}
+	for _, s := range []*sessions.Session{legacy, fresh} {
+		if got := loaded[s.ID].Values["format"]; got != s.Values["format"] {
+			t.Errorf("Expected format %v; Got %v", s.Values["format"], got)
+		}
+	}
+
+	bad := MultiSerializer{Preferred: "xml"}
+	if _, err = bad.Serialize(fresh); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+	if err = bad.Deserialize([]byte("\x00xml\x00<a/>"), fresh); err == nil {
+		t.Errorf("Expected an error for an unknown tag")
+	}
+}
+
+func TestOnOversize(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetMaxLength(100)
+	var gotSize, gotLimit int
+	store.SetOnOversize(func(session *sessions.Session, size, limit int) {
+		gotSize, gotLimit = size, limit
+		delete(session.Values, "big")
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	session.Values["big"] = strings.Repeat("x", 200)
+	if err = session.Save(req, NewRecorder()); err != ErrSessionTooBig {
+		t.Fatalf("Expected ErrSessionTooBig; Got %v", err)
+	}
+	if gotSize <= 200 || gotLimit != 100 {
+		t.Errorf("Expected size > 200 and limit 100; Got %d and %d", gotSize, gotLimit)
+	}
+
+	// The hook trimmed the session, so a retry succeeds.
+	gotSize = 0
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving trimmed session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+	if gotSize != 0 {
+		t.Errorf("Expected the hook not to fire for a session within the limit")
+	}
+}
+
+func TestJSONSerializerDeterministic(t *testing.T) {
+	values := func() map[interface{}]interface{} {
+		m := make(map[interface{}]interface{})
+		for i := 0; i < 50; i++ {
+			m[fmt.Sprintf("key%d", i)] = map[string]interface{}{"b": i, "a": []interface{}{"x", i}, "c": time.Unix(int64(i), 0).UTC()}
+		}
+		return m
+	}
+	var first []byte
+	for i := 0; i < 10; i++ {
+		session := sessions.NewSession(nil, "session-key")
+		session.Values = values()
+		b, err := JSONSerializer{}.Serialize(session)
+		if err != nil {
+			t.Fatalf("Error serializing session: %v", err)
+		}
+		if first == nil {
+			first = b
+		} else if !bytes.Equal(b, first) {
+			t.Fatalf("Expected identical values to serialize identically")
+		}
+	}
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSerializer(JSONSerializer{})
+	store.SetSkipUnchanged(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	session.Values["n"] = 1.0
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+
+	load := func() *sessions.Session {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+		loaded, err := store.New(req, "session-key")
+		if err != nil || loaded.IsNew {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return loaded
+	}
+
+	// Overwrite the stored bytes with an equivalent payload and a short TTL:
+	// a skipped SET leaves the marker in place but still extends the TTL.
+	loaded := load()
+	if len(loaded.Values) != 2 {
+		t.Errorf("Expected only the session's own values; Got %v", loaded.Values)
+	}
+	stored, _ := store.Client.Get(ctx, key).Result()
+	store.Client.Set(ctx, key, stored, time.Minute)
+	store.Client.Append(ctx, key, " ")
+	if err = store.Save(req, NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving unchanged session: %v", err)
+	}
+	if got, _ := store.Client.Get(ctx, key).Result(); got != stored+" " {
+		t.Errorf("Expected an unchanged session not to be rewritten")
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl <= time.Minute {
+		t.Errorf("Expected the TTL to be extended; Got %v", ttl)
+	}
+
+	// A changed session is written.
+	loaded.Values["foo"] = "baz"
+	if err = store.Save(req, NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving changed session: %v", err)
+	}
+	if got := load().Values["foo"]; got != "baz" {
+		t.Errorf("Expected the changed value to be saved; Got %v", got)
+	}
+
+	// An unchanged session whose key expired is written again.
+	unchanged := load()
+	store.Client.Del(ctx, key)
+	if err = store.Save(req, NewRecorder(), unchanged); err != nil {
+		t.Fatalf("Error saving expired session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, key).Result(); n != 1 {
+		t.Errorf("Expected an expired session to be rewritten")
+	}
+}
+
+func TestContextWithFormat(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	req = req.WithContext(ContextWithFormat(req.Context(), "json"))
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	data, _ := store.Client.Get(ctx, store.key(session.ID)).Bytes()
+	if !bytes.HasPrefix(data, []byte(formatMagic+"\x00json\x00{")) {
+		t.Errorf("Expected tagged JSON; Got %q", data)
+	}
+
+	// The store default is still Gob, but the tag selects JSON on read.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected foo=bar; Got %v", loaded.Values["foo"])
+	}
+
+	req = req.WithContext(ContextWithFormat(req.Context(), "xml"))
+	if err = loaded.Save(req, NewRecorder()); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+// zeroPrefixSerializer is a binary format whose output starts with 0x00, like
+// the MultiSerializer tag.
+type zeroPrefixSerializer struct{ GobSerializer }
+
+func (s zeroPrefixSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	b, err := s.GobSerializer.Serialize(ss)
+	return append([]byte{0, 1, 2}, b...), err
+}
+
+func (s zeroPrefixSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	if !bytes.HasPrefix(d, []byte{0, 1, 2}) {
+		return errors.New("missing zeroPrefixSerializer header")
+	}
+	return s.GobSerializer.Deserialize(d[3:], ss)
+}
+
+func TestZeroPrefixedSerializer(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSerializer(zeroPrefixSerializer{})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected foo=bar; Got %v", loaded.Values["foo"])
+	}
+	if keys, err := store.ValueKeys(ctx, session.ID); err != nil || len(keys) != 1 {
+		t.Errorf("Expected one value key; Got %v, %v", keys, err)
+	}
+}
+
+func TestAbsoluteTimeout(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetAbsoluteTimeout(time.Hour)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key, key+createdAtSuffix)
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Hour {
+		t.Errorf("Expected a new session's TTL to be capped at 1h; Got %v", ttl)
+	}
+
+	// Near the end of its lifetime, a save only extends it to the cap.
+	session.IsNew = false
+	created := time.Now().Add(-59 * time.Minute)
+	store.Client.Set(ctx, key+createdAtSuffix, created.UnixNano(), time.Hour)
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Minute || ttl <= 0 {
+		t.Errorf("Expected TTL of at most 1m; Got %v", ttl)
+	}
+	if err = store.SaveMerge(req, NewRecorder(), session); err != nil {
+		t.Fatalf("Error merging session: %v", err)
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Minute || ttl <= 0 {
+		t.Errorf("Expected merged TTL of at most 1m; Got %v", ttl)
+	}
+
+	// Past it, the session can no longer be renewed.
+	store.Client.Set(ctx, key+createdAtSuffix, time.Now().Add(-2*time.Hour).UnixNano(), time.Hour)
+	if err = session.Save(req, NewRecorder()); err != ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired; Got %v", err)
+	}
+}
+
+func TestAbsoluteTimeoutLoadAndTouch(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetAbsoluteTimeout(time.Minute)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key, key+createdAtSuffix)
+
+	touched := sessions.NewSession(store, "session-key")
+	touched.ID = session.ID
+	if ok, err := store.LoadAndTouch(ctx, touched, time.Hour); err != nil || !ok {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Minute || ttl <= 0 {
+		t.Errorf("Expected the TTL to be capped at 1m; Got %v", ttl)
+	}
+
+	// A session saved before tracking started is stamped now.
+	store.Client.Del(ctx, key+createdAtSuffix)
+	if _, err = store.LoadAndTouch(ctx, touched, time.Hour); err != nil {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, key+createdAtSuffix).Result(); n != 1 {
+		t.Error("Expected the creation time to be stamped")
+	}
+
+	store.Client.Set(ctx, key+createdAtSuffix, time.Now().Add(-2*time.Minute).UnixNano(), time.Hour)
+	if _, err = store.LoadAndTouch(ctx, touched, time.Hour); err != ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired; Got %v", err)
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Minute {
+		t.Errorf("Expected an expired session not to be extended; Got %v", ttl)
+	}
+}
+
+func TestInvalidCookiePolicy(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	// A cookie signed with another key.
+	other, err := NewGoRediStore(10, "tcp", setup(), "", []byte("other-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := other.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer other.Client.Del(ctx, other.key(session.ID))
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+		return req
+	}
+	got, err := store.New(newRequest(), "session-key")
+	if err != nil {
+		t.Errorf("Expected no error with NewSession; Got %v", err)
+	}
+	if !got.IsNew || got.ID != "" || len(got.Values) != 0 {
+		t.Errorf("Expected an empty new session; Got %v", got)
+	}
+
+	store.SetInvalidCookiePolicy(ReturnError)
+	got, err = store.New(newRequest(), "session-key")
+	if err == nil {
+		t.Errorf("Expected a decode error with ReturnError")
+	}
+	if got == nil || !got.IsNew {
+		t.Errorf("Expected a new session along with the error")
+	}
+}
+
+func TestCount(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix(fmt.Sprintf("count_%d_", time.Now().UnixNano()))
+	store.SetTrackCreatedAt(true)
+	defer store.Flush(context.Background())
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for i := 0; i < 3; i++ {
+		session, _ := store.New(req, "session-key")
+		session.Values["i"] = i
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	if n, err := store.Count(context.Background()); err != nil || n != 3 {
+		t.Errorf("Expected 3 sessions; Got %d, %v", n, err)
+	}
+}
+
+func TestLoadEmptyValue(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSerializer(emptySerializer{})
+
+	load := func(id string) (*sessions.Session, error) {
+		encoded, err := securecookie.EncodeMulti("session-key", id, store.Codecs...)
+		if err != nil {
+			t.Fatalf("Error encoding cookie: %v", err)
+		}
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.AddCookie(&http.Cookie{Name: "session-key", Value: encoded})
+		return store.New(req, "session-key")
+	}
+
+	// A missing key is a new session.
+	session, err := load("missing-" + store.newID(nil))
+	if err != nil || !session.IsNew {
+		t.Errorf("Expected a new session for a missing key; Got %v, %v", session.IsNew, err)
+	}
+
+	// A present but empty value is deserialized.
+	id := "empty-" + store.newID(nil)
+	store.Client.Set(ctx, store.key(id), "", time.Minute)
+	defer store.Client.Del(ctx, store.key(id))
+	session, err = load(id)
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected an existing session for an empty value; Got %v, %v", session.IsNew, err)
+	}
+	if session.Values["empty"] != true {
+		t.Errorf("Expected the empty value to be deserialized")
+	}
+
+	// With a serializer that rejects it, the error surfaces.
+	store.SetSerializer(GobSerializer{})
+	if _, err = load(id); err == nil {
+		t.Errorf("Expected Gob to fail on an empty value")
+	}
+}
+
+// emptySerializer marks sessions it deserializes from an empty payload.
+type emptySerializer struct{ GobSerializer }
+
+func (s emptySerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	if len(d) == 0 {
+		ss.Values["empty"] = true
+		return nil
+	}
+	return s.GobSerializer.Deserialize(d, ss)
+}
+
+// prefixCodec is a securecookie.Codec that is not a *securecookie.SecureCookie.
+type prefixCodec struct{ securecookie.Codec }
+
+func (c prefixCodec) Encode(name string, value interface{}) (string, error) {
+	s, err := c.Codec.Encode(name, value)
+	return "custom." + s, err
+}
+
+func (c prefixCodec) Decode(name, value string, dst interface{}) error {
+	if !strings.HasPrefix(value, "custom.") {
+		return errors.New("missing prefix")
+	}
+	return c.Codec.Decode(name, strings.TrimPrefix(value, "custom."), dst)
+}
+
+func TestCustomCodecs(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	codec := prefixCodec{securecookie.New([]byte("session-key"), nil)}
+	store, err := NewGoRediStoreWithCodecs(client, []securecookie.Codec{codec})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetMaxAge(3600) // must skip the custom codec
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+	cookie := rsp.HeaderMap["Set-Cookie"][0]
+	if !strings.HasPrefix(cookie, "session-key=custom.") {
+		t.Errorf("Expected the custom codec to encode the cookie; Got %q", cookie)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected the custom codec to decode the cookie; Got %v, %v", loaded.Values, err)
+	}
+
+	store.SetCodecs(securecookie.CodecsFromPairs([]byte("other-key")))
+	store.SetInvalidCookiePolicy(ReturnError)
+	if _, err = store.New(req, "session-key"); err == nil {
+		t.Errorf("Expected SetCodecs to replace the custom codec")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	ctx := context.Background()
+	path := os.Getenv("REDIS_SOCKET")
+	if path == "" {
+		t.Skip("REDIS_SOCKET not set")
+	}
+	store, err := NewGoRediStoreWithUnixSocket(path, "", []byte("session-key"))
+	if err != nil {
+		t.Fatalf("Error connecting over %s: %v", path, err)
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected to load the session over the socket; Got %v, %v", loaded.Values, err)
+	}
+}
+
+func TestLoadAndTouch(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetTrackCreatedAt(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.SaveWithTTL(req, NewRecorder(), session, time.Minute); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key, key+createdAtSuffix)
+
+	loaded := sessions.NewSession(store, "session-key")
+	loaded.ID = session.ID
+	ok, err := store.LoadAndTouch(context.Background(), loaded, time.Hour)
+	if err != nil || !ok {
+		t.Fatalf("Error loading session: %v, %v", ok, err)
+	}
+	if loaded.Values["foo"] != "bar" || loaded.IsNew {
+		t.Errorf("Expected the stored values; Got %v", loaded.Values)
+	}
+	for _, k := range []string{key, key + createdAtSuffix} {
+		if ttl, _ := store.Client.PTTL(ctx, k).Result(); ttl <= time.Minute {
+			t.Errorf("Expected %s TTL to be extended; Got %v", k, ttl)
+		}
+	}
+
+	missing := sessions.NewSession(store, "session-key")
+	missing.ID = "missing-" + store.newID(nil)
+	if ok, err = store.LoadAndTouch(context.Background(), missing, time.Hour); err != nil || ok {
+		t.Errorf("Expected a missing session not to be found; Got %v, %v", ok, err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(missing.ID)).Result(); n != 0 {
+		t.Errorf("Expected LoadAndTouch not to create a missing session")
+	}
+}
+
+func TestCookieNameFunc(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetCookieNameFunc(func(r *http.Request, baseName string) string {
+		return baseName + "_" + strings.SplitN(r.Host, ".", 2)[0]
+	})
+
+	req, _ := http.NewRequest("GET", "http://tenantA.example.com/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "sessionid")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+	cookie := rsp.HeaderMap["Set-Cookie"][0]
+	if !strings.HasPrefix(cookie, "sessionid_tenantA=") {
+		t.Fatalf("Expected a tenant-specific cookie name; Got %q", cookie)
+	}
+
+	req, _ = http.NewRequest("GET", "http://tenantA.example.com/", nil)
+	req.Header.Add("Cookie", cookie)
+	loaded, err := store.New(req, "sessionid")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected to read the tenant cookie; Got %v, %v", loaded.Values, err)
+	}
+	if id, err := store.VerifyCookie(req, "sessionid"); err != nil || id != session.ID {
+		t.Errorf("Expected VerifyCookie to use the derived name; Got %q, %v", id, err)
+	}
+
+	// Another tenant neither reads this cookie nor accepts it renamed.
+	req, _ = http.NewRequest("GET", "http://tenantB.example.com/", nil)
+	req.Header.Add("Cookie", strings.Replace(cookie, "tenantA", "tenantB", 1))
+	store.SetInvalidCookiePolicy(ReturnError)
+	if _, err = store.New(req, "sessionid"); err == nil {
+		t.Errorf("Expected a cookie signed for tenantA not to decode for tenantB")
+	}
+}
+
+func TestVerifyKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	prefix := fmt.Sprintf("verify_%d_", time.Now().UnixNano())
+	store.SetKeyPrefix(prefix)
+	defer store.Flush(context.Background())
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	double := prefix + prefix + store.newID(nil)
+	odd := prefix + "not-an-id"
+	store.Client.Set(ctx, double, "x", time.Minute)
+	store.Client.Set(ctx, odd, "x", time.Minute)
+
+	issues, err := store.VerifyKeys(context.Background())
+	if err != nil {
+		t.Fatalf("Error verifying keys: %v", err)
+	}
+	if len(issues) != 2 {
+		t.Fatalf("Expected 2 issues; Got %v", issues)
+	}
+	for _, want := range []string{double + ": key prefix", odd + ": session ID"} {
+		found := false
+		for _, issue := range issues {
+			found = found || strings.HasPrefix(issue, want)
+		}
+		if !found {
+			t.Errorf("Expected an issue starting with %q; Got %v", want, issues)
+		}
+	}
+}
+
+// streamSerializer is a StreamSerializer whose byte-slice methods must not
+// be used.
+type streamSerializer struct{}
+
+func (streamSerializer) Serialize(*sessions.Session) ([]byte, error) {
+	return nil, errors.New("Serialize called")
+}
+
+func (streamSerializer) Deserialize([]byte, *sessions.Session) error {
+	return errors.New("Deserialize called")
+}
+
+func (streamSerializer) SerializeTo(w io.Writer, ss *sessions.Session) error {
+	enc := gob.NewEncoder(w)
+	// Write in two messages, so the limit is checked across writes.
+	if err := enc.Encode(len(ss.Values)); err != nil {
+		return err
+	}
+	return enc.Encode(ss.Values)
+}
+
+func (streamSerializer) DeserializeFrom(r io.Reader, ss *sessions.Session) error {
+	dec := gob.NewDecoder(r)
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		return err
+	}
+	return dec.Decode(&ss.Values)
+}
+
+func TestStreamSerializer(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSerializer(streamSerializer{})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected a streamed round-trip; Got %v, %v", loaded.Values, err)
+	}
+
+	var gotSize int
+	store.SetOnOversize(func(_ *sessions.Session, size, _ int) { gotSize = size })
+	store.SetMaxLength(100)
+	session.Values["big"] = strings.Repeat("x", 200)
+	if err = session.Save(req, NewRecorder()); err != ErrSessionTooBig {
+		t.Errorf("Expected ErrSessionTooBig; Got %v", err)
+	}
+	if gotSize <= 200 {
+		t.Errorf("Expected the full size to be counted; Got %d", gotSize)
+	}
+}
+
+func TestKeyHashing(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyHashing(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	sum := sha256.Sum256([]byte(session.ID))
+	key := "session_" + hex.EncodeToString(sum[:])
+	if got := store.RedisKey(session); got != key {
+		t.Errorf("Expected key %q; Got %q", key, got)
+	}
+	if n, _ := store.Client.Exists(ctx, key).Result(); n != 1 {
+		t.Fatalf("Expected the session under the hashed key")
+	}
+	if n, _ := store.Client.Exists(ctx, "session_"+session.ID).Result(); n != 0 {
+		t.Errorf("Expected no key under the raw ID")
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" || loaded.ID != session.ID {
+		t.Errorf("Expected the session to round-trip; Got %v, %v", loaded.Values, err)
+	}
+
+	loaded.Options.MaxAge = -1
+	if err = loaded.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, key).Result(); n != 0 {
+		t.Errorf("Expected delete to remove the hashed key")
+	}
+
+	// A hash tag stays in clear.
+	if got := store.RedisKeyForID("{alice}ID"); !strings.HasPrefix(got, "session_{alice}") || len(got) != len("session_{alice}")+64 {
+		t.Errorf("Expected a tagged hashed key; Got %q", got)
+	}
+}
+
+func TestDeleteGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetTrackCreatedAt(true)
+	store.SetDeleteGracePeriod(2 * time.Second)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key, key+createdAtSuffix)
+	cookie := rsp.HeaderMap["Set-Cookie"][0]
+
+	session.Options.MaxAge = -1
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+
+	// A concurrent request within the grace period still sees it.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected the session within the grace period; Got %v, %v", loaded.Values, err)
+	}
+	for _, k := range []string{key, key + createdAtSuffix} {
+		if ttl, _ := store.Client.PTTL(ctx, k).Result(); ttl <= 0 || ttl > 2*time.Second {
+			t.Errorf("Expected %s to expire within the grace period; Got %v", k, ttl)
+		}
+	}
+}
+
+func TestContextPassedToClient(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(context.Background(), store.key(session.ID))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = store.TTL(ctx, session.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from TTL; Got %v", err)
+	}
+	if err = session.Save(req.WithContext(ctx), NewRecorder()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Save; Got %v", err)
+	}
+	if ok, err := store.Exists(context.Background(), session.ID); err != nil || !ok {
+		t.Errorf("Expected the session to still exist; Got %v, %v", ok, err)
+	}
+}
+
+func TestSaveIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	first := sessions.NewSession(store, "session-key")
+	first.Options = &sessions.Options{MaxAge: 300}
+	first.Values["owner"] = "first"
+	created, err := store.SaveIfAbsent(ctx, first)
+	if err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if !created || first.ID == "" {
+		t.Errorf("Expected the session to be created with an ID; Got %v, %q", created, first.ID)
+	}
+	defer store.Client.Del(ctx, store.key(first.ID))
+
+	second := sessions.NewSession(store, "session-key")
+	second.Options = &sessions.Options{MaxAge: 300}
+	second.ID = first.ID
+	second.Values["owner"] = "second"
+	if created, err = store.SaveIfAbsent(ctx, second); err != nil || created {
+		t.Errorf("Expected created=false and no error; Got %v, %v", created, err)
+	}
+
+	loaded := sessions.NewSession(store, "session-key")
+	loaded.ID = first.ID
+	if ok, err := store.load(ctx, loaded); err != nil || !ok {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.Values["owner"] != "first" {
+		t.Errorf("Expected the first session to be kept; Got %v", loaded.Values["owner"])
+	}
+}
+
+// panicValue panics when gob decodes it, and when gob encodes it if
+// failEncode is set.
+type panicValue struct{ failEncode bool }
+
+func (v panicValue) GobEncode() ([]byte, error) {
+	if v.failEncode {
+		panic("cannot encode")
+	}
+	return []byte{}, nil
+}
+
+func (*panicValue) GobDecode([]byte) error { panic("cannot decode") }
+
+func TestGobSerializerPanics(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["fn"] = func() {}
+	if err = session.Save(req, NewRecorder()); err == nil {
+		t.Error("Expected an error saving a func value")
+	}
+
+	gob.Register(panicValue{})
+	in := sessions.NewSession(nil, "session-key")
+	in.Values["p"] = panicValue{failEncode: true}
+	if _, err = (GobSerializer{}).Serialize(in); err == nil || !strings.Contains(err.Error(), "cannot encode") {
+		t.Errorf("Expected the encode panic as an error; Got %v", err)
+	}
+
+	in.Values["p"] = panicValue{}
+	data, err := GobSerializer{}.Serialize(in)
+	if err != nil {
+		t.Fatalf("Error serializing session: %v", err)
+	}
+	out := sessions.NewSession(nil, "session-key")
+	if err = (GobSerializer{}).Deserialize(data, out); err == nil || !strings.Contains(err.Error(), "cannot decode") {
+		t.Errorf("Expected the decode panic as an error; Got %v", err)
+	}
+}
+
+func TestSetOptionsForName(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetOptionsForName("auth", sessions.Options{Path: "/account", MaxAge: 3600})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	for _, name := range []string{"auth", "cart"} {
+		session, err := store.Get(req, name)
+		if err != nil {
+			t.Fatalf("Error getting session: %v", err)
+		}
+		if err = session.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		defer store.Client.Del(context.Background(), store.key(session.ID))
+	}
+
+	cookies := (&http.Response{Header: rsp.Header()}).Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies; Got %d", len(cookies))
+	}
+	if c := cookies[0]; c.Path != "/account" || c.MaxAge != 3600 {
+		t.Errorf("Expected auth cookie with Path /account and MaxAge 3600; Got %q, %d", c.Path, c.MaxAge)
+	}
+	if c := cookies[1]; c.Path != "/" || c.MaxAge != sessionExpire {
+		t.Errorf("Expected cart cookie with the default options; Got %q, %d", c.Path, c.MaxAge)
+	}
+}
+
+func TestGetWithResult(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	// Miss: no cookie.
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, loaded, err := store.GetWithResult(req, "session-key")
+	if err != nil || loaded {
+		t.Errorf("Expected a new session; Got loaded=%v, %v", loaded, err)
+	}
+	if got, ok := session.Store().(*GoRediStore); !ok || got != store {
+		t.Errorf("Expected the session to belong to the store; Got %T", session.Store())
+	}
+	rsp := NewRecorder()
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(context.Background(), store.key(session.ID))
+	cookie := rsp.HeaderMap["Set-Cookie"][0]
+
+	// Hit: loaded from redis.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if _, loaded, err = store.GetWithResult(req, "session-key"); err != nil || !loaded {
+		t.Errorf("Expected the session to be loaded; Got loaded=%v, %v", loaded, err)
+	}
+
+	// Registry-cached: the same request returns the session without a load.
+	cached, loaded, err := store.GetWithResult(req, "session-key")
+	if err != nil || loaded || cached.IsNew {
+		t.Errorf("Expected the cached session; Got loaded=%v, IsNew=%v, %v", loaded, cached.IsNew, err)
+	}
+
+	// Miss: the cookie names a session that is gone.
+	store.Client.Del(context.Background(), store.key(session.ID))
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if _, loaded, err = store.NewWithResult(req, "session-key"); err != nil || loaded {
+		t.Errorf("Expected a new session for a deleted one; Got loaded=%v, %v", loaded, err)
+	}
+}
+
+func TestPreserveTTLOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetPreserveTTLOnUpdate(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Options.MaxAge = 300
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+	if err = store.Client.Expire(ctx, key, 100*time.Second).Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	session.IsNew = false
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+	if ttl, _ := store.Client.TTL(ctx, key).Result(); ttl <= 90*time.Second || ttl > 100*time.Second {
+		t.Errorf("Expected the update to keep the TTL; Got %v", ttl)
+	}
+	if v, _ := store.Client.Get(ctx, key).Result(); v == "" {
+		t.Error("Expected the update to be written")
+	}
+
+	// A loaded session whose key expired is written with a fresh TTL.
+	store.Client.Del(ctx, key)
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if ttl, _ := store.Client.TTL(ctx, key).Result(); ttl <= 290*time.Second {
+		t.Errorf("Expected a fresh TTL; Got %v", ttl)
+	}
+}
+
+func TestRawValue(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSerializer(JSONSerializer{})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	want, err := store.serialize(ctx, session)
+	if err != nil {
+		t.Fatalf("Error serializing session: %v", err)
+	}
+	got, err := store.RawValue(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Error reading raw value: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %q; Got %q", want, got)
+	}
+	if _, err = store.RawValue(ctx, "missing"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestWithOnConnect(t *testing.T) {
+	ctx := context.Background()
+	var connects int32
+	client := redis.NewClient(&redis.Options{Addr: setup(), PoolSize: 2})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")}, WithOnConnect(func(ctx context.Context, cn *redis.Conn) error {
+		atomic.AddInt32(&connects, 1)
+		return cn.Ping(ctx).Err()
+	}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Errorf("Expected the hook to run for the initial ping's connection; Got %d calls", n)
+	}
+
+	// Two connections in use at once: the second one is new.
+	tx := client.Conn()
+	defer tx.Close()
+	if err = tx.Ping(ctx).Err(); err != nil {
+		t.Fatalf("Error pinging: %v", err)
+	}
+	if _, err = store.Exists(ctx, "any"); err != nil {
+		t.Fatalf("Error checking session: %v", err)
+	}
+	if n := atomic.LoadInt32(&connects); n != 2 {
+		t.Errorf("Expected the hook to run for the second connection; Got %d calls", n)
+	}
+}
+
+func TestWithProtocol(t *testing.T) {
+	ctx := context.Background()
+	for _, protocol := range []int{2, 3} {
+		client := redis.NewClient(&redis.Options{Addr: setup()})
+		store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")}, WithProtocol(protocol))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer store.Close()
+		if got := client.Options().Protocol; got != protocol {
+			t.Errorf("Expected protocol %d; Got %d", protocol, got)
+		}
+		// HELLO replies with a map only under RESP3.
+		hello, err := client.Do(ctx, "HELLO", protocol).Result()
+		if err != nil {
+			t.Fatalf("Error negotiating RESP%d: %v", protocol, err)
+		}
+		if _, isMap := hello.(map[interface{}]interface{}); isMap != (protocol == 3) {
+			t.Errorf("RESP%d: unexpected HELLO reply %T", protocol, hello)
+		}
+
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["user"] = "alice"
+		if err = session.Save(req, rsp); err != nil {
+			t.Fatalf("RESP%d: Error saving session: %v", protocol, err)
+		}
+		defer store.Client.Del(ctx, store.key(session.ID))
+		if ttl, err := store.TTL(ctx, session.ID); err != nil || ttl <= 0 {
+			t.Errorf("RESP%d: Expected a TTL; Got %v, %v", protocol, ttl, err)
+		}
+		req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+		loaded, err := store.New(req, "session-key")
+		if err != nil || loaded.IsNew || loaded.Values["user"] != "alice" {
+			t.Errorf("RESP%d: Expected the session to round-trip; Got %v, %v", protocol, loaded.Values, err)
+		}
+	}
+}
+
+func TestIDValidator(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	errBadID := errors.New("bad session ID")
+	store.SetIDValidator(func(id string) error {
+		if strings.ContainsAny(id, " *?[]") {
+			return errBadID
+		}
+		return nil
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.ID = "restored *"
+	rsp := NewRecorder()
+	if err = session.Save(req, rsp); err != errBadID {
+		t.Errorf("Expected the invalid ID to be rejected; Got %v", err)
+	}
+	if len(rsp.HeaderMap["Set-Cookie"]) != 0 {
+		t.Error("Expected no cookie for a rejected ID")
+	}
+	if n, _ := store.Client.Exists(ctx, store.key("restored *")).Result(); n != 0 {
+		t.Error("Expected nothing stored for a rejected ID")
+	}
+
+	session.ID = "restored"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key("restored"))
+	if ok, _ := store.Exists(ctx, "restored"); !ok {
+		t.Error("Expected the valid preset ID to be used")
+	}
+
+	// Generated IDs are not checked.
+	store.SetIDValidator(func(string) error { return errBadID })
+	fresh, _ := store.New(req, "session-key")
+	if err = fresh.Save(req, NewRecorder()); err != nil {
+		t.Errorf("Expected a generated ID to be saved; Got %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(fresh.ID))
+}
+
+func TestDestroy(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	rsp = NewRecorder()
+	if err = store.Destroy(req, rsp, "session-key"); err != nil {
+		t.Fatalf("Error destroying session: %v", err)
+	}
+	if ok, _ := store.Exists(ctx, session.ID); ok {
+		t.Error("Expected the session to be deleted from redis")
+	}
+	cookies := (&http.Response{Header: rsp.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected an expired cookie; Got %v", cookies)
+	}
+	if current, _ := store.Get(req, "session-key"); len(current.Values) != 0 {
+		t.Errorf("Expected the request's session to be cleared; Got %v", current.Values)
+	}
+
+	// Without a session there is only a cookie to expire.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp = NewRecorder()
+	if err = store.Destroy(req, rsp, "session-key"); err != nil {
+		t.Fatalf("Error destroying missing session: %v", err)
+	}
+	if cookies := (&http.Response{Header: rsp.Header()}).Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected an expired cookie; Got %v", cookies)
+	}
+}
+
+func TestSetEnvironment(t *testing.T) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("env_%d_", time.Now().UnixNano())
+	stores := map[string]*GoRediStore{}
+	for _, env := range []string{"staging", "prod"} {
+		store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer store.Close()
+		store.SetKeyPrefix(prefix)
+		store.SetEnvironment(env)
+		defer store.Flush(ctx)
+		stores[env] = store
+	}
+	staging, prod := stores["staging"], stores["prod"]
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := staging.New(req, "session-key")
+	if err := session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if key := staging.key(session.ID); key != "staging:"+prefix+session.ID {
+		t.Errorf("Expected the key to carry the environment; Got %q", key)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	if loaded, err := prod.New(req, "session-key"); err != nil || !loaded.IsNew {
+		t.Errorf("Expected prod not to see the staging session; Got IsNew=%v, %v", loaded.IsNew, err)
+	}
+	if n, _ := staging.Count(ctx); n != 1 {
+		t.Errorf("Expected 1 staging session; Got %d", n)
+	}
+	if n, _ := prod.Count(ctx); n != 0 {
+		t.Errorf("Expected no prod sessions; Got %d", n)
+	}
+	if _, err := prod.Flush(ctx); err != nil {
+		t.Fatalf("Error flushing prod: %v", err)
+	}
+	if ok, _ := staging.Exists(ctx, session.ID); !ok {
+		t.Error("Expected flushing prod to leave staging alone")
+	}
+}
+
+func TestLegacyBase64Decode(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetLegacyBase64Decode(true)
+
+	legacy := sessions.NewSession(store, "session-key")
+	legacy.Values["user"] = "alice"
+	plain, err := GobSerializer{}.Serialize(legacy)
+	if err != nil {
+		t.Fatalf("Error serializing session: %v", err)
+	}
+	key := store.key("legacy")
+	store.Client.Set(ctx, key, base64.StdEncoding.EncodeToString(plain), time.Minute)
+	defer store.Client.Del(ctx, key)
+
+	session := sessions.NewSession(store, "session-key")
+	session.ID = "legacy"
+	if ok, err := store.load(ctx, session); err != nil || !ok {
+		t.Fatalf("Error loading legacy session: %v", err)
+	}
+	if session.Values["user"] != "alice" {
+		t.Errorf("Expected the legacy values; Got %v", session.Values)
+	}
+
+	// Current values load as before.
+	if err = store.save(ctx, session, time.Minute); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if raw, _ := store.RawValue(ctx, "legacy"); !bytes.Equal(raw, plain) {
+		t.Errorf("Expected the session to be saved in the plain format; Got %q", raw)
+	}
+	reloaded := sessions.NewSession(store, "session-key")
+	reloaded.ID = "legacy"
+	if ok, err := store.load(ctx, reloaded); err != nil || !ok || reloaded.Values["user"] != "alice" {
+		t.Errorf("Expected the plain session to load; Got %v, %v", reloaded.Values, err)
+	}
+}
+
+func TestListByTTL(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix(fmt.Sprintf("ttl_%d_", time.Now().UnixNano()))
+	defer store.Flush(ctx)
+
+	for id, ttl := range map[string]time.Duration{"c": 300 * time.Second, "a": 100 * time.Second, "b": 200 * time.Second} {
+		store.Client.Set(ctx, store.key(id), "x", ttl)
+	}
+	store.Client.Set(ctx, store.key("persistent"), "x", 0)
+
+	list, err := store.ListByTTL(ctx, 0)
+	if err != nil {
+		t.Fatalf("Error listing sessions: %v", err)
+	}
+	var ids []string
+	for _, info := range list {
+		ids = append(ids, info.ID)
+	}
+	if got := strings.Join(ids, ","); got != "a,b,c,persistent" {
+		t.Errorf("Expected a,b,c,persistent; Got %s", got)
+	}
+	if list[0].TTL <= 90*time.Second || list[0].TTL > 100*time.Second || list[3].TTL != NoExpiry {
+		t.Errorf("Expected the TTLs to be reported; Got %v", list)
+	}
+
+	if list, _ = store.ListByTTL(ctx, 2); len(list) != 2 || list[1].ID != "b" {
+		t.Errorf("Expected the 2 sessions expiring soonest; Got %v", list)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+	store.Client.Expire(ctx, key, 100*time.Second)
+
+	replacement := sessions.NewSession(store, "session-key")
+	replacement.Values["user"] = "admin-as-alice"
+	if err = store.Replace(ctx, session.ID, replacement); err != nil {
+		t.Fatalf("Error replacing session: %v", err)
+	}
+	loaded := sessions.NewSession(store, "session-key")
+	loaded.ID = session.ID
+	if ok, err := store.load(ctx, loaded); err != nil || !ok || loaded.Values["user"] != "admin-as-alice" {
+		t.Errorf("Expected the replaced values; Got %v, %v", loaded.Values, err)
+	}
+	if ttl, _ := store.Client.TTL(ctx, key).Result(); ttl <= 90*time.Second || ttl > 100*time.Second {
+		t.Errorf("Expected the TTL to be preserved; Got %v", ttl)
+	}
+
+	if err = store.Replace(ctx, "missing", replacement); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, store.key("missing")).Result(); n != 0 {
+		t.Error("Expected Replace not to create a session")
+	}
+}
+
+// paddingCodec inflates encoded values, like a codec embedding extra claims.
+type paddingCodec struct{ securecookie.Codec }
+
+func (c paddingCodec) Encode(name string, value interface{}) (string, error) {
+	s, err := c.Codec.Encode(name, value)
+	return s + strings.Repeat("x", 4096), err
+}
+
+func TestMaxCookieLength(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	codec := paddingCodec{securecookie.New([]byte("session-key"), nil)}
+	store, err := NewGoRediStoreWithCodecs(client, []securecookie.Codec{codec})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = session.Save(req, rsp); err != ErrCookieTooLarge {
+		t.Fatalf("Expected ErrCookieTooLarge; Got %v", err)
+	}
+	if len(rsp.Header()["Set-Cookie"]) != 0 {
+		t.Error("Expected no cookie to be emitted")
+	}
+	if n, _ := store.Client.Exists(ctx, store.key(session.ID)).Result(); n != 0 {
+		t.Error("Expected the session not to be stored")
+	}
+
+	store.SetMaxCookieLength(0)
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+	if len(rsp.Header()["Set-Cookie"]) != 1 {
+		t.Error("Expected a cookie with the check disabled")
+	}
+}
+
+// gobTypesValue is only registered through WithGobTypes.
+type gobTypesValue struct {
+	Name  string
+	Roles []string
+}
+
+func TestWithGobTypes(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")}, WithGobTypes(gobTypesValue{}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = gobTypesValue{Name: "alice", Roles: []string{"admin"}}
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	loaded := sessions.NewSession(store, "session-key")
+	loaded.ID = session.ID
+	if _, err = store.load(ctx, loaded); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if user, ok := loaded.Values["user"].(gobTypesValue); !ok || user.Name != "alice" || len(user.Roles) != 1 {
+		t.Errorf("Expected the registered struct to round-trip; Got %#v", loaded.Values["user"])
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	if n, err := store.MemoryUsage(ctx, session.ID); err != nil || n <= 0 {
+		t.Errorf("Expected a positive memory usage; Got %d, %v", n, err)
+	}
+	if _, err = store.MemoryUsage(ctx, "missing"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+// failingScanHook fails every SCAN after the first `ok` ones, like a node
+// failing over in the middle of a walk. Test servers that return every key
+// in one reply have theirs cut to scanCount keys, so a walk takes several.
+type failingScanHook struct {
+	ok       int32
+	scans    int32
+	disabled int32
+}
+
+func (h *failingScanHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *failingScanHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		scan, ok := cmd.(*redis.ScanCmd)
+		if !ok || atomic.LoadInt32(&h.disabled) == 1 {
+			return next(ctx, cmd)
+		}
+		if atomic.AddInt32(&h.scans, 1) > h.ok {
+			err := errors.New("LOADING Redis is loading the dataset in memory")
+			cmd.SetErr(err)
+			return err
+		}
+		err := next(ctx, cmd)
+		if keys, cursor := scan.Val(); err == nil && cursor == 0 && len(keys) > scanCount {
+			scan.SetVal(keys[:scanCount], 1)
+		}
+		return err
+	}
+}
+
+func (h *failingScanHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestScanPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix("partial_scan_")
+	defer store.Flush(ctx)
+
+	if _, err = store.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < 3*scanCount; i++ {
+			pipe.Set(ctx, store.key(fmt.Sprint("id", i)), "x", time.Minute)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Error storing sessions: %v", err)
+	}
+
+	hook := &failingScanHook{ok: 1}
+	client.AddHook(hook)
+	n, err := store.Count(ctx)
+	if err == nil || n == 0 || n >= 3*scanCount {
+		t.Errorf("Expected a partial count and an error; Got %d, %v", n, err)
+	}
+	atomic.StoreInt32(&hook.scans, 0)
+	deleted, err := store.Flush(ctx)
+	if err == nil || !strings.Contains(err.Error(), "SCAN at cursor") {
+		t.Errorf("Expected the SCAN error; Got %v", err)
+	}
+	if deleted != n {
+		t.Errorf("Expected %d sessions deleted before the failure; Got %d", n, deleted)
+	}
+
+	// Flushing again resumes the job.
+	atomic.StoreInt32(&hook.disabled, 1)
+	rest, err := store.Flush(ctx)
+	if err != nil {
+		t.Fatalf("Error flushing store: %v", err)
+	}
+	if deleted+rest != 3*scanCount {
+		t.Errorf("Expected %d sessions deleted in total; Got %d", 3*scanCount, deleted+rest)
+	}
+}
+
+// countingHook counts the commands sent to redis.
+type countingHook struct{ cmds int32 }
+
+func (h *countingHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *countingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		atomic.AddInt32(&h.cmds, 1)
+		return next(ctx, cmd)
+	}
+}
+
+func (h *countingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		atomic.AddInt32(&h.cmds, int32(len(cmds)))
+		return next(ctx, cmds)
+	}
+}
+
+func TestRefreshCookie(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	hook := &countingHook{}
+	client.AddHook(hook)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.RefreshCookie(NewRecorder(), session); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound for an unsaved session; Got %v", err)
+	}
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+
+	store.Codecs = securecookie.CodecsFromPairs([]byte("rotated-key"))
+	session.Options.SameSite = http.SameSiteStrictMode
+	atomic.StoreInt32(&hook.cmds, 0)
+	rsp := NewRecorder()
+	if err = store.RefreshCookie(rsp, session); err != nil {
+		t.Fatalf("Error refreshing cookie: %v", err)
+	}
+	if n := atomic.LoadInt32(&hook.cmds); n != 0 {
+		t.Errorf("Expected no redis commands; Got %d", n)
+	}
+	cookies := (&http.Response{Header: rsp.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected one cookie with the new SameSite; Got %v", cookies)
+	}
+	var id string
+	if err = securecookie.New([]byte("rotated-key"), nil).Decode("session-key", cookies[0].Value, &id); err != nil || id != session.ID {
+		t.Errorf("Expected the ID encoded with the rotated key; Got %q, %v", id, err)
+	}
+
+	store.SetCookieNameFunc(func(r *http.Request, name string) string { return name + "_tenant" })
+	rsp = NewRecorder()
+	if err = store.RefreshCookie(rsp, session); err != ErrCookieNameFunc {
+		t.Errorf("Expected ErrCookieNameFunc with a cookie name func; Got %v", err)
+	}
+	if c := rsp.Header().Get("Set-Cookie"); c != "" {
+		t.Errorf("Expected no cookie with a cookie name func; Got %q", c)
+	}
+}
+
+// stampedCookie encodes id like a securecookie codec with hashKey and no
+// block key, but with the given timestamp, as if issued by a skewed clock.
+func stampedCookie(hashKey []byte, name, id string, ts int64) string {
+	b, _ := securecookie.GobEncoder{}.Serialize(id)
+	msg := fmt.Sprintf("%s|%d|%s|", name, ts, base64.URLEncoding.EncodeToString(b))
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(msg[:len(msg)-1]))
+	return base64.URLEncoding.EncodeToString(append([]byte(msg[len(name)+1:]), mac.Sum(nil)...))
+}
+
+func TestTimestampSkew(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetMaxAge(60)
+
+	now := time.Now().Unix()
+	decodes := func(ts int64) bool {
+		var id string
+		cookie := stampedCookie([]byte("session-key"), "session-key", "ID", ts)
+		return securecookie.DecodeMulti("session-key", cookie, &id, store.Codecs...) == nil && id == "ID"
+	}
+	if decodes(now - 75) {
+		t.Error("Expected a cookie older than MaxAge to be rejected without skew")
+	}
+
+	store.SetTimestampSkew(30 * time.Second)
+	if !decodes(now + 20) {
+		t.Error("Expected a cookie from a clock running ahead to be accepted")
+	}
+	if !decodes(now - 75) {
+		t.Error("Expected a cookie within the skew window to be accepted")
+	}
+	if decodes(now - 120) {
+		t.Error("Expected a cookie beyond the skew window to be rejected")
+	}
+
+	// The skew survives a change of MaxAge.
+	store.SetMaxAge(300)
+	if !decodes(now - 315) {
+		t.Error("Expected the skew to apply to the new MaxAge")
+	}
+}
+
+func TestKeyBuilder(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyBuilder(func(session *sessions.Session) string {
+		return "user:{" + session.ID + "}:session"
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.ID = "42"
+	session.Values["name"] = "alice"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, "user:{42}:session")
+	if key := store.RedisKey(session); key != "user:{42}:session" {
+		t.Errorf("Expected key user:{42}:session; Got %s", key)
+	}
+	if n, _ := store.Client.Exists(ctx, "user:{42}:session", store.key("42")).Result(); n != 1 {
+		t.Errorf("Expected only the built key to be written; Got %d keys", n)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header()["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["name"] != "alice" {
+		t.Fatalf("Expected the session to round-trip; Got %v, %v", loaded.Values, err)
+	}
+
+	loaded.Options.MaxAge = -1
+	if err = loaded.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, "user:{42}:session").Result(); n != 0 {
+		t.Error("Expected the built key to be deleted")
+	}
+
+	derived := store.Derive("derived_")
+	if key := derived.RedisKey(session); key != "derived_42" {
+		t.Errorf("Expected the derived store to use its prefix; Got %s", key)
+	}
+}
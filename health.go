@@ -0,0 +1,110 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// healthCheckAttempts is how many PINGs a health check sends before it
+// reports a failure, so a single dropped pooled connection does not flip the
+// status.
+const healthCheckAttempts = 2
+
+// healthChecker holds the state of a store's background health checks.
+type healthChecker struct {
+	mu          sync.Mutex
+	ok          bool
+	lastErr     error
+	lastChecked time.Time
+	stop        chan struct{} // closed to stop the running goroutine
+	done        chan struct{} // closed when the running goroutine exits
+}
+
+// StartHealthChecks starts a goroutine that PINGs redis every interval and
+// records the result for HealthStatus, so health endpoints can report the
+// last known state without a round-trip each. The first check runs
+// immediately. Calling it again restarts the checks with the new interval.
+// The goroutine is stopped by StopHealthChecks or Close. It returns
+// ErrInvalidInterval, leaving any running checks alone, if interval is not
+// positive.
+func (s *GoRediStore) StartHealthChecks(interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInvalidInterval
+	}
+	s.StopHealthChecks()
+	h := s.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stop = make(chan struct{})
+	h.done = make(chan struct{})
+	go s.runHealthChecks(interval, h.stop, h.done)
+	return nil
+}
+
+// StopHealthChecks stops the health check goroutine, if it is running, and
+// waits for it to exit. The last recorded status is kept.
+func (s *GoRediStore) StopHealthChecks() {
+	h := s.health
+	h.mu.Lock()
+	stop, done := h.stop, h.done
+	h.stop, h.done = nil, nil
+	h.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// HealthStatus returns the result of the most recent background health
+// check: whether redis answered, the error if it did not, and when the check
+// finished. Before the first check it returns false, nil and the zero time.
+func (s *GoRediStore) HealthStatus() (ok bool, lastErr error, lastChecked time.Time) {
+	h := s.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ok, h.lastErr, h.lastChecked
+}
+
+func (s *GoRediStore) runHealthChecks(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.checkHealth(interval, stop)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkHealth PINGs redis, each attempt bounded by timeout, and records the
+// outcome.
+func (s *GoRediStore) checkHealth(timeout time.Duration, stop chan struct{}) {
+	var err error
+	for i := 0; i < healthCheckAttempts; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = s.Client.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			break
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+	h := s.health
+	h.mu.Lock()
+	h.ok, h.lastErr, h.lastChecked = err == nil, err, time.Now()
+	h.mu.Unlock()
+}
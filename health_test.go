@@ -0,0 +1,96 @@
+package goredistore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// flakyConn fails every write while down is set.
+type flakyConn struct {
+	net.Conn
+	down *int32
+}
+
+func (c flakyConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(c.down) == 1 {
+		return 0, errors.New("simulated outage")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestHealthChecks(t *testing.T) {
+	var down int32
+	client := redis.NewClient(&redis.Options{
+		Addr: setup(),
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if atomic.LoadInt32(&down) == 1 {
+				return nil, errors.New("simulated outage")
+			}
+			c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return flakyConn{c, &down}, err
+		},
+	})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	if ok, _, checked := store.HealthStatus(); ok || !checked.IsZero() {
+		t.Errorf("Expected no status before the first check")
+	}
+
+	waitFor := func(want bool) error {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if ok, err, _ := store.HealthStatus(); ok == want {
+				return err
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Expected health status %v", want)
+		return nil
+	}
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := store.StartHealthChecks(interval); err != ErrInvalidInterval {
+			t.Errorf("Expected ErrInvalidInterval for %v; Got %v", interval, err)
+		}
+	}
+	if store.health.done != nil {
+		t.Errorf("Expected no health checks to start")
+	}
+
+	if err := store.StartHealthChecks(10 * time.Millisecond); err != nil {
+		t.Fatalf("Error starting health checks: %v", err)
+	}
+	if err := waitFor(true); err != nil {
+		t.Errorf("Expected no error while healthy; Got %v", err)
+	}
+	atomic.StoreInt32(&down, 1)
+	if err := waitFor(false); err == nil {
+		t.Errorf("Expected the outage error")
+	}
+	atomic.StoreInt32(&down, 0)
+	waitFor(true)
+
+	store.StopHealthChecks()
+	_, _, checked := store.HealthStatus()
+	time.Sleep(30 * time.Millisecond)
+	if _, _, again := store.HealthStatus(); !again.Equal(checked) {
+		t.Errorf("Expected no checks after StopHealthChecks")
+	}
+
+	// Close stops a running checker too.
+	store.StartHealthChecks(10 * time.Millisecond)
+	store.Close()
+	if store.health.done != nil {
+		t.Errorf("Expected Close to stop health checks")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// keepAliveJitter is the fraction of the interval by which KeepAlive varies
+// each wait, so connections opened together do not refresh together.
+const keepAliveJitter = 0.1
+
+// KeepAlive resets the TTL of the session with the given ID right away and
+// then about every interval, until ctx is done, e.g. for the lifetime of a
+// websocket whose user sends no requests that would save the session. The
+// TTL is the one Save gives a session with the store's Options, so interval
+// should be well below it. Each wait varies by up to 10% of interval.
+//
+// KeepAlive blocks; run it in its own goroutine. It returns ctx's error once
+// ctx is done, ErrSessionNotFound as soon as the session no longer exists,
+// e.g. after logout, ErrSessionExpired once it reaches SetAbsoluteTimeout's
+// cap, which the TTL is never reset past, or the first error from redis.
+func (s *GoRediStore) KeepAlive(ctx context.Context, id string, interval time.Duration) error {
+	session := sessions.NewSession(s, "")
+	session.ID = id
+	session.Options = s.Options
+	ttl, err := s.ttl(session)
+	if err != nil {
+		return err
+	}
+	for {
+		if err = s.SetTTL(ctx, id, ttl); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		jitter := time.Duration((rand.Float64()*2 - 1) * keepAliveJitter * float64(interval))
+		t := time.NewTimer(interval + jitter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.Options.MaxAge = 300
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(context.Background(), key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- store.KeepAlive(ctx, session.ID, 20*time.Millisecond) }()
+
+	// Each shortened TTL is reset on a later tick.
+	for i := 0; i < 3; i++ {
+		store.Client.Expire(context.Background(), key, 10*time.Second)
+		time.Sleep(50 * time.Millisecond)
+		if ttl, _ := store.Client.TTL(context.Background(), key).Result(); ttl <= 10*time.Second {
+			t.Errorf("Expected the TTL to be refreshed; Got %v", ttl)
+		}
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled; Got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected KeepAlive to return after cancel")
+	}
+	store.Client.Expire(context.Background(), key, 10*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if ttl, _ := store.Client.TTL(context.Background(), key).Result(); ttl > 10*time.Second {
+		t.Errorf("Expected no refresh after cancel; Got %v", ttl)
+	}
+
+	// A deleted session stops it.
+	store.Client.Del(context.Background(), key)
+	if err = store.KeepAlive(context.Background(), session.ID, time.Hour); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestKeepAliveAbsoluteTimeout(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetAbsoluteTimeout(time.Minute)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key, key+createdAtSuffix)
+
+	if err = store.SetTTL(ctx, session.ID, 2*time.Hour); err != nil {
+		t.Fatalf("Error setting TTL: %v", err)
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Minute || ttl <= 0 {
+		t.Errorf("Expected SetTTL to be capped at 1m; Got %v", ttl)
+	}
+
+	// Past the cap, KeepAlive stops instead of renewing the session.
+	store.Client.Set(ctx, key+createdAtSuffix, time.Now().Add(-2*time.Minute).UnixNano(), time.Hour)
+	done := make(chan error, 1)
+	go func() { done <- store.KeepAlive(ctx, session.ID, 10*time.Millisecond) }()
+	select {
+	case err = <-done:
+		if err != ErrSessionExpired {
+			t.Errorf("Expected ErrSessionExpired; Got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected KeepAlive to stop at the absolute timeout")
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl > time.Minute {
+		t.Errorf("Expected the session not to be renewed; Got %v", ttl)
+	}
+}
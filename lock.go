@@ -0,0 +1,78 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"sync"
+
+	"github.com/gorilla/sessions"
+)
+
+// sessionLocks holds a mutex for each session currently locked or waited for
+// by WithLockedSession or a save. Entries are removed once unused, so
+// sessions that are never shared cost nothing after their request.
+var sessionLocks = struct {
+	mu    sync.Mutex
+	locks map[*sessions.Session]*sessionLock
+}{locks: make(map[*sessions.Session]*sessionLock)}
+
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockSession locks the session and returns the func that unlocks it.
+func lockSession(session *sessions.Session) (unlock func()) {
+	sessionLocks.mu.Lock()
+	l, ok := sessionLocks.locks[session]
+	if !ok {
+		l = &sessionLock{}
+		sessionLocks.locks[session] = l
+	}
+	l.refs++
+	sessionLocks.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		sessionLocks.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(sessionLocks.locks, session)
+		}
+		sessionLocks.mu.Unlock()
+	}
+}
+
+// WithLockedSession runs fn while holding a lock on the session, so
+// goroutines that share a session, such as concurrent WebSocket handlers,
+// can read and write session.Values without racing: every access to Values
+// from those goroutines must happen inside fn. With SetValuesMutex(true) the
+// store's saves take the same lock. fn must not save the session itself,
+// which would deadlock; save it after WithLockedSession returns.
+func WithLockedSession(session *sessions.Session, fn func()) {
+	defer lockSession(session)()
+	fn()
+}
+
+// SetValuesMutex makes saving a session, with Save, SaveWithTTL, SaveMerge,
+// SaveIfAbsent or Replace, hold the lock WithLockedSession takes while the
+// store reads and updates session.Values, so a session may be saved while
+// other goroutines change it through WithLockedSession. Sessions are loaded
+// before they can be shared, so New needs no lock.
+// Default: false.
+func (s *GoRediStore) SetValuesMutex(b bool) {
+	s.valuesMutex = b
+}
+
+// lockValues locks the session if SetValuesMutex is enabled, and returns the
+// func that unlocks it.
+func (s *GoRediStore) lockValues(session *sessions.Session) (unlock func()) {
+	if !s.valuesMutex {
+		return func() {}
+	}
+	return lockSession(session)
+}
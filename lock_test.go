@@ -0,0 +1,61 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// TestValuesMutex is meant to be run with -race: goroutines sharing a session
+// write its values through WithLockedSession while it is being saved.
+func TestValuesMutex(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetValuesMutex(true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, nil, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(context.Background(), store.key(session.ID))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			WithLockedSession(session, func() {
+				n, _ := session.Values["count"].(int)
+				session.Values["count"] = n + 1
+			})
+			errs <- store.Save(req, nil, session)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+
+	loaded := sessions.NewSession(store, "session-key")
+	loaded.ID = session.ID
+	if _, err = store.load(context.Background(), loaded); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.Values["count"] != 10 {
+		t.Errorf("Expected count 10; Got %v", loaded.Values["count"])
+	}
+	if len(sessionLocks.locks) != 0 {
+		t.Errorf("Expected no locks to be left; Got %d", len(sessionLocks.locks))
+	}
+}
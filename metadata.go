@@ -0,0 +1,149 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"encoding/gob"
+	"runtime"
+	"sync"
+	"weak"
+
+	"github.com/gorilla/sessions"
+)
+
+// metadataField is the reserved top-level key session metadata is stored
+// under in the serialized session.
+const metadataField = "_goredistore_metadata"
+
+func init() {
+	gob.Register(map[string]interface{}{})
+}
+
+// SetMetadata attaches server-only metadata, such as the login method or a
+// device ID, to the session. Metadata is saved and loaded with the session's
+// values, under a reserved key in the stored blob, but is not addressable
+// through session.Values. As with Values, value types must be supported by
+// the store's serializer. The session must belong to a GoRediStore, as
+// sessions from its New do; metadata set on other sessions is dropped.
+func SetMetadata(session *sessions.Session, key string, value interface{}) {
+	if s, ok := session.Store().(*GoRediStore); ok {
+		s.setMetadata(session, key, value)
+	}
+}
+
+// GetMetadata returns the metadata value set for key with SetMetadata, or
+// nil if there is none.
+func GetMetadata(session *sessions.Session, key string) interface{} {
+	if s, ok := session.Store().(*GoRediStore); ok {
+		return s.metadata(session, key)
+	}
+	return nil
+}
+
+func (s *GoRediStore) setMetadata(session *sessions.Session, key string, value interface{}) {
+	st := s.states.ensure(session)
+	if st.meta == nil {
+		st.meta = make(map[string]interface{})
+	}
+	st.meta[key] = value
+}
+
+func (s *GoRediStore) metadata(session *sessions.Session, key string) interface{} {
+	if st := s.states.get(session); st != nil {
+		return st.meta[key]
+	}
+	return nil
+}
+
+// sessionState is what the store keeps about a session outside its Values,
+// so handlers iterating or counting Values only see their own keys.
+type sessionState struct {
+	meta   map[string]interface{} // see SetMetadata
+	synced []byte                 // stored value as last loaded or saved, if any
+}
+
+// sessionStates holds the state of the sessions a store loaded or saved. It
+// is keyed by weak pointers, so it does not keep sessions alive, and an entry
+// is removed by a cleanup once its session is collected, so sessions that are
+// loaded but never saved do not leak it. Unlike a finalizer, a cleanup may be
+// attached to a session embedded in a larger struct, or one the caller has
+// set a finalizer on.
+type sessionStates struct {
+	m sync.Map // weak.Pointer[sessions.Session] -> *sessionState
+}
+
+// get returns the session's state, or nil if it has none.
+func (ss *sessionStates) get(session *sessions.Session) *sessionState {
+	if st, ok := ss.m.Load(weak.Make(session)); ok {
+		return st.(*sessionState)
+	}
+	return nil
+}
+
+// ensure returns the session's state, creating it if needed.
+func (ss *sessionStates) ensure(session *sessions.Session) *sessionState {
+	p := weak.Make(session)
+	if st, ok := ss.m.Load(p); ok {
+		return st.(*sessionState)
+	}
+	st, loaded := ss.m.LoadOrStore(p, &sessionState{})
+	if !loaded {
+		runtime.AddCleanup(session, func(p weak.Pointer[sessions.Session]) { ss.m.Delete(p) }, p)
+	}
+	return st.(*sessionState)
+}
+
+// syncedValue returns the stored value the session was last loaded from or
+// saved as, or nil.
+func (s *GoRediStore) syncedValue(session *sessions.Session) []byte {
+	if st := s.states.get(session); st != nil {
+		return st.synced
+	}
+	return nil
+}
+
+// setSynced records b as the session's stored value. Clearing a value that
+// was never recorded does not create a state.
+func (s *GoRediStore) setSynced(session *sessions.Session, b []byte) {
+	if b == nil {
+		if st := s.states.get(session); st != nil {
+			st.synced = nil
+		}
+		return
+	}
+	s.states.ensure(session).synced = b
+}
+
+// storedSession returns the session as it is handed to the serializer: if it
+// has metadata, a copy with the metadata added to its values under
+// metadataField.
+func (s *GoRediStore) storedSession(session *sessions.Session) *sessions.Session {
+	st := s.states.get(session)
+	if st == nil || len(st.meta) == 0 {
+		return session
+	}
+	stored := *session
+	stored.Values = make(map[interface{}]interface{}, len(session.Values)+1)
+	for k, v := range session.Values {
+		stored.Values[k] = v
+	}
+	stored.Values[metadataField] = st.meta
+	return &stored
+}
+
+// restoreMetadata moves metadata read under metadataField by the serializer
+// out of the session's values into its state.
+func (s *GoRediStore) restoreMetadata(session *sessions.Session) {
+	meta, ok := session.Values[metadataField]
+	if !ok {
+		return
+	}
+	delete(session.Values, metadataField)
+	if m, ok := meta.(map[string]interface{}); ok {
+		s.states.ensure(session).meta = m
+	}
+}
@@ -0,0 +1,100 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	for _, serializer := range []SessionSerializer{GobSerializer{}, JSONSerializer{}} {
+		store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer store.Close()
+		store.SetSerializer(serializer)
+
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["foo"] = "bar"
+		SetMetadata(session, "login", "password")
+		SetMetadata(session, "device", "laptop")
+		if err = session.Save(req, rsp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		defer store.Client.Del(ctx, store.key(session.ID))
+
+		req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+		loaded, err := store.New(req, "session-key")
+		if err != nil || loaded.IsNew {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		if got := GetMetadata(loaded, "login"); got != "password" {
+			t.Errorf("%T: Expected login metadata %q; Got %v", serializer, "password", got)
+		}
+		if got := GetMetadata(loaded, "device"); got != "laptop" {
+			t.Errorf("%T: Expected device metadata %q; Got %v", serializer, "laptop", got)
+		}
+		if got := GetMetadata(loaded, "missing"); got != nil {
+			t.Errorf("%T: Expected nil for missing metadata; Got %v", serializer, got)
+		}
+		if len(loaded.Values) != 1 || loaded.Values["foo"] != "bar" {
+			t.Errorf("%T: Expected only foo in Values; Got %v", serializer, loaded.Values)
+		}
+		keys, err := store.ValueKeys(context.Background(), session.ID)
+		if err != nil {
+			t.Fatalf("Error listing value keys: %v", err)
+		}
+		if len(keys) != 1 || keys[0] != "foo" {
+			t.Errorf("%T: Expected keys [foo]; Got %v", serializer, keys)
+		}
+	}
+}
+
+func TestSessionStateReleased(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	count := func() int {
+		n := 0
+		store.states.m.Range(func(_, _ interface{}) bool { n++; return true })
+		return n
+	}
+	for i := 0; i < 10; i++ {
+		SetMetadata(sessions.NewSession(store, "session-key"), "device", "laptop")
+	}
+	// Sessions embedded in a struct, or with a finalizer of their own, are
+	// tracked too.
+	embedded := &struct {
+		n int
+		sessions.Session
+	}{}
+	embedded.Session = *sessions.NewSession(store, "session-key")
+	SetMetadata(&embedded.Session, "device", "phone")
+	finalized := sessions.NewSession(store, "session-key")
+	runtime.SetFinalizer(finalized, func(*sessions.Session) {})
+	SetMetadata(finalized, "device", "tablet")
+	if GetMetadata(&embedded.Session, "device") != "phone" || GetMetadata(finalized, "device") != "tablet" {
+		t.Errorf("Expected metadata on embedded and finalized sessions")
+	}
+	embedded, finalized = nil, nil
+
+	deadline := time.Now().Add(2 * time.Second)
+	for count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the state of collected sessions to be released; Got %d entries", count())
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
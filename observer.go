@@ -0,0 +1,51 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import "time"
+
+// Observer is notified after every save, load, delete and merge a store
+// performs against redis, e.g. to export metrics. op is "save", "load",
+// "delete" or "merge". err is the operation's error; a load that finds no
+// session is not an error.
+//
+// Observe is called synchronously on the request path and from concurrent
+// requests, so it must be fast and safe for concurrent use.
+type Observer interface {
+	Observe(op string, d time.Duration, err error)
+}
+
+// SaveObserver is an Observer that is also told the size of each saved
+// session, e.g. to build a payload size histogram for tuning SetMaxLength.
+// ObserveSave is called after Observe for every save and merge; size is the
+// length of the serialized value written to redis, or 0 if serialization
+// failed.
+type SaveObserver interface {
+	Observer
+	ObserveSave(size int, d time.Duration, err error)
+}
+
+// SetObserver sets the store's observer, which may also implement
+// SaveObserver. Pass nil to disable.
+func (s *GoRediStore) SetObserver(o Observer) {
+	s.observer = o
+}
+
+// observe reports the operation started at start, with the error err points
+// to, to the observer. It is meant to be deferred.
+func (s *GoRediStore) observe(op string, start time.Time, err *error) {
+	if s.observer != nil {
+		s.observer.Observe(op, time.Since(start), *err)
+	}
+}
+
+// observeSave is like observe for the size of a saved session.
+func (s *GoRediStore) observeSave(start time.Time, size *int, err *error) {
+	if o, ok := s.observer.(SaveObserver); ok {
+		o.ObserveSave(*size, time.Since(start), *err)
+	}
+}
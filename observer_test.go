@@ -0,0 +1,110 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (o *recordingObserver) Observe(op string, d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err != nil {
+		op += " error"
+	}
+	o.ops = append(o.ops, op)
+}
+
+func TestObserver(t *testing.T) {
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	o := &recordingObserver{}
+	store.SetObserver(o)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	if session, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if err = store.SaveMerge(req, NewRecorder(), session); err != nil {
+		t.Fatalf("Error merging session: %v", err)
+	}
+	session.Options.MaxAge = -1
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	store.SetMaxLength(1)
+	session.Options.MaxAge = 60
+	session.Save(req, NewRecorder())
+
+	want := []string{"save", "load", "merge", "delete", "save error"}
+	if len(o.ops) != len(want) {
+		t.Fatalf("Expected %v; Got %v", want, o.ops)
+	}
+	for i := range want {
+		if o.ops[i] != want[i] {
+			t.Errorf("Expected %v; Got %v", want, o.ops)
+			break
+		}
+	}
+}
+
+type sizeObserver struct {
+	recordingObserver
+	sizes []int
+}
+
+func (o *sizeObserver) ObserveSave(size int, d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sizes = append(o.sizes, size)
+}
+
+func TestSaveObserver(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	o := &sizeObserver{}
+	store.SetObserver(o)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer store.Client.Del(ctx, store.key(session.ID))
+	if err = store.SaveMerge(req, NewRecorder(), session); err != nil {
+		t.Fatalf("Error merging session: %v", err)
+	}
+
+	stored, _ := store.Client.StrLen(ctx, store.key(session.ID)).Result()
+	if len(o.sizes) != 2 {
+		t.Fatalf("Expected 2 reported sizes; Got %v", o.sizes)
+	}
+	for _, size := range o.sizes {
+		if int64(size) != stored {
+			t.Errorf("Expected size %d, the bytes written; Got %d", stored, size)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package prometheus exports go-redistore metrics to Prometheus. It lives in
+// its own package so the core store does not depend on the Prometheus client.
+//
+//	collector := prometheus.NewPrometheusCollector(store)
+//	registry.MustRegister(collector)
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	redistore "github.com/allen-woods/go-redistore"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// countTimeout bounds the SCAN sampling the sessions gauge on each scrape.
+const countTimeout = 5 * time.Second
+
+// Collector is a prometheus.Collector exposing a store's operation counters
+// and its number of sessions.
+type Collector struct {
+	store    *redistore.GoRediStore
+	ops      *prom.CounterVec
+	errors   *prom.CounterVec
+	sizes    prom.Histogram
+	sessions *prom.Desc
+}
+
+// NewPrometheusCollector returns a collector for the store and installs it as
+// the store's Observer, replacing any observer set before. It exposes:
+//
+//	goredistore_operations_total{op}        save, load, delete and merge calls
+//	goredistore_operation_errors_total{op}  those that failed
+//	goredistore_session_size_bytes          serialized size of saved sessions
+//	goredistore_sessions                    sessions under the store's prefix
+//
+// The sessions gauge is sampled with store.Count on every scrape, which SCANs
+// the keyspace; scrape large databases infrequently.
+func NewPrometheusCollector(store *redistore.GoRediStore) *Collector {
+	c := &Collector{
+		store: store,
+		ops: prom.NewCounterVec(prom.CounterOpts{
+			Name: "goredistore_operations_total",
+			Help: "Redis operations performed by the session store.",
+		}, []string{"op"}),
+		errors: prom.NewCounterVec(prom.CounterOpts{
+			Name: "goredistore_operation_errors_total",
+			Help: "Redis operations of the session store that failed.",
+		}, []string{"op"}),
+		sizes: prom.NewHistogram(prom.HistogramOpts{
+			Name:    "goredistore_session_size_bytes",
+			Help:    "Serialized size of sessions saved by the session store.",
+			Buckets: prom.ExponentialBuckets(64, 2, 10), // 64B to 32KB
+		}),
+		sessions: prom.NewDesc("goredistore_sessions",
+			"Sessions stored under the store's key prefix.", nil, nil),
+	}
+	store.SetObserver(c)
+	return c
+}
+
+// Observe implements redistore.Observer.
+func (c *Collector) Observe(op string, d time.Duration, err error) {
+	c.ops.WithLabelValues(op).Inc()
+	if err != nil {
+		c.errors.WithLabelValues(op).Inc()
+	}
+}
+
+// ObserveSave implements redistore.SaveObserver.
+func (c *Collector) ObserveSave(size int, d time.Duration, err error) {
+	if err == nil {
+		c.sizes.Observe(float64(size))
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.ops.Describe(ch)
+	c.errors.Describe(ch)
+	c.sizes.Describe(ch)
+	ch <- c.sessions
+}
+
+// Collect implements prometheus.Collector. If counting the sessions fails,
+// the gauge reports the error and fails the scrape.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.ops.Collect(ch)
+	c.errors.Collect(ch)
+	c.sizes.Collect(ch)
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+	n, err := c.store.Count(ctx)
+	if err != nil {
+		ch <- prom.NewInvalidMetric(c.sessions, err)
+		return
+	}
+	ch <- prom.MustNewConstMetric(c.sessions, prom.GaugeValue, float64(n))
+}
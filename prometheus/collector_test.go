@@ -0,0 +1,81 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	redistore "github.com/allen-woods/go-redistore"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func setup() string {
+	addr := os.Getenv("REDIS_HOST")
+	if addr == "" {
+		addr = "127.0.0.1"
+	}
+	port := os.Getenv("REDIS_PORT")
+	if port == "" {
+		port = "6379"
+	}
+	return fmt.Sprintf("%s:%s", addr, port)
+}
+
+func TestCollector(t *testing.T) {
+	store, err := redistore.NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetKeyPrefix(fmt.Sprintf("prom_%d_", time.Now().UnixNano()))
+	defer store.Flush(context.Background())
+	collector := NewPrometheusCollector(store)
+	registry := prom.NewPedanticRegistry()
+	registry.MustRegister(collector)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for i := 0; i < 2; i++ {
+		session, _ := store.New(req, "session-key")
+		session.Values["i"] = i
+		if err = session.Save(req, httptest.NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	store.SetMaxLength(1)
+	session, _ := store.New(req, "session-key")
+	session.Values["big"] = "value"
+	session.Save(req, httptest.NewRecorder())
+
+	expected := `
+# HELP goredistore_operation_errors_total Redis operations of the session store that failed.
+# TYPE goredistore_operation_errors_total counter
+goredistore_operation_errors_total{op="save"} 1
+# HELP goredistore_operations_total Redis operations performed by the session store.
+# TYPE goredistore_operations_total counter
+goredistore_operations_total{op="save"} 3
+# HELP goredistore_sessions Sessions stored under the store's key prefix.
+# TYPE goredistore_sessions gauge
+goredistore_sessions 2
+`
+	names := []string{"goredistore_operations_total", "goredistore_operation_errors_total", "goredistore_sessions"}
+	if err = testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(collector, "goredistore_session_size_bytes"); n != 1 {
+		t.Errorf("Expected a size histogram; Got %d series", n)
+	}
+	families, _ := registry.Gather()
+	for _, f := range families {
+		if f.GetName() == "goredistore_session_size_bytes" {
+			if got := f.GetMetric()[0].GetHistogram().GetSampleCount(); got != 2 {
+				t.Errorf("Expected 2 sizes for the successful saves; Got %d", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+// schemaVersionMetadata is the metadata key the session's schema version is
+// stored under, see SetSchemaVersion.
+const schemaVersionMetadata = "schema_version"
+
+// SetSchemaVersion sets the version of the shape of session values the
+// application currently writes. Sessions saved without a version are
+// stamped with v, in their metadata under "schema_version"; loaded sessions
+// keep the version they were stored with unless a migrator upgrades them.
+// Sessions stored before versioning was enabled are version 0.
+// Default: 0.
+func (s *GoRediStore) SetSchemaVersion(v int) {
+	s.schemaVersion = v
+}
+
+// SetSchemaMigrator sets a function that upgrades the values of a loaded
+// session whose schema version differs from the one set with
+// SetSchemaVersion, e.g. to rename fields across a rolling deploy instead of
+// forcing users to log in again. It is called by New with the stored version
+// and values, without the store's private entries such as metadata, and
+// returns the new version and values. The migrated session is written back
+// to redis, keeping its TTL, unless write-behind is enabled, in which case it
+// is written on its next save. If fn returns an error, loading fails with it.
+// Pass nil to load sessions as stored.
+func (s *GoRediStore) SetSchemaMigrator(fn func(version int, values map[interface{}]interface{}) (int, map[interface{}]interface{}, error)) {
+	s.migrator = fn
+}
+
+// schemaVersionOf returns the schema version stored in the session's
+// metadata, or 0 if there is none. Depending on the serializer, the number
+// may decode as another numeric type.
+func (s *GoRediStore) schemaVersionOf(session *sessions.Session) (int, bool) {
+	switch v := s.metadata(session, schemaVersionMetadata).(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// stampSchemaVersion records the current schema version on a session that
+// has none.
+func (s *GoRediStore) stampSchemaVersion(session *sessions.Session) {
+	if s.schemaVersion == 0 {
+		return
+	}
+	if _, ok := s.schemaVersionOf(session); !ok {
+		s.setMetadata(session, schemaVersionMetadata, s.schemaVersion)
+	}
+}
+
+// migrate upgrades the values of a loaded session with the schema migrator,
+// if its version is not the current one, and writes the result back.
+func (s *GoRediStore) migrate(ctx context.Context, c *redis.Client, session *sessions.Session) error {
+	if s.migrator == nil {
+		return nil
+	}
+	version, _ := s.schemaVersionOf(session)
+	if version == s.schemaVersion {
+		return nil
+	}
+	v, values, err := s.migrator(version, session.Values)
+	if err != nil {
+		return err
+	}
+	if values == nil {
+		values = make(map[interface{}]interface{})
+	}
+	session.Values = values
+	s.setSynced(session, nil) // the values changed: never skip the next save
+	s.setMetadata(session, schemaVersionMetadata, v)
+	if s.writeBehind != nil {
+		return nil
+	}
+	b, err := s.serialize(ctx, session)
+	if err != nil {
+		return err
+	}
+	return s.guard(func() error {
+		err := c.SetArgs(ctx, s.sessionKey(session), b, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+		if err == redis.Nil {
+			return nil // expired meanwhile
+		}
+		if err == nil {
+			s.setSynced(session, b)
+		}
+		return err
+	})
+}
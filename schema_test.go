@@ -0,0 +1,114 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSchemaMigrator(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSchemaVersion(1)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["name"] = "Alice"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+	store.Client.Expire(ctx, key, 100*time.Second)
+	cookie := rsp.HeaderMap["Set-Cookie"][0]
+
+	// The next deploy renames "name" to "full_name".
+	store.SetSchemaVersion(2)
+	var calls int
+	store.SetSchemaMigrator(func(version int, values map[interface{}]interface{}) (int, map[interface{}]interface{}, error) {
+		calls++
+		if version == 1 {
+			values["full_name"] = values["name"]
+			delete(values, "name")
+		}
+		return 2, values, nil
+	})
+	load := func() map[interface{}]interface{} {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		loaded, err := store.New(req, "session-key")
+		if err != nil || loaded.IsNew {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		if v, _ := store.schemaVersionOf(loaded); v != 2 {
+			t.Errorf("Expected schema version 2; Got %d", v)
+		}
+		return loaded.Values
+	}
+	values := load()
+	if values["full_name"] != "Alice" || values["name"] != nil {
+		t.Errorf("Expected the migrated values; Got %v", values)
+	}
+
+	// The migrated session was written back with its TTL.
+	if values = load(); values["full_name"] != "Alice" || calls != 1 {
+		t.Errorf("Expected the stored session to be migrated once; Got %v after %d calls", values, calls)
+	}
+	if ttl, _ := store.Client.TTL(ctx, key).Result(); ttl > 100*time.Second {
+		t.Errorf("Expected the write-back to keep the TTL; Got %v", ttl)
+	}
+}
+
+func TestSchemaMigratorLoadAndTouchAndPreload(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.SetSchemaVersion(1)
+
+	save := func() string {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values["old"] = "x"
+		if err := session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return session.ID
+	}
+	touched, preloaded := save(), save()
+	defer store.Client.Del(ctx, store.key(touched), store.key(preloaded))
+
+	store.SetSchemaVersion(2)
+	store.SetSchemaMigrator(func(version int, values map[interface{}]interface{}) (int, map[interface{}]interface{}, error) {
+		values["new"] = values["old"]
+		delete(values, "old")
+		return 2, values, nil
+	})
+
+	session := sessions.NewSession(store, "session-key")
+	session.ID = touched
+	if ok, err := store.LoadAndTouch(ctx, session, time.Minute); err != nil || !ok {
+		t.Fatalf("Error loading session: %v, %v", ok, err)
+	}
+	if session.Values["new"] != "x" || session.Values["old"] != nil {
+		t.Errorf("Expected LoadAndTouch to migrate the values; Got %v", session.Values)
+	}
+
+	loaded, err := store.PreloadByIDs(ctx, "session-key", []string{preloaded})
+	if err != nil || loaded[preloaded] == nil {
+		t.Fatalf("Error preloading session: %v", err)
+	}
+	if values := loaded[preloaded].Values; values["new"] != "x" || values["old"] != nil {
+		t.Errorf("Expected PreloadByIDs to migrate the values; Got %v", values)
+	}
+}
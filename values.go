@@ -0,0 +1,77 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"math"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// GetString returns the session value stored under key if it is a string.
+func GetString(session *sessions.Session, key interface{}) (string, bool) {
+	v, ok := session.Values[key].(string)
+	return v, ok
+}
+
+// GetInt returns the session value stored under key as an int. Besides the
+// integer types it accepts a float64 holding a whole number, which is how
+// JSONSerializer decodes numbers.
+func GetInt(session *sessions.Session, key interface{}) (int, bool) {
+	switch v := session.Values[key].(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		if int64(int(v)) == v {
+			return int(v), true
+		}
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 && float64(int(v)) == v {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// GetFloat64 returns the session value stored under key as a float64,
+// converting integer types.
+func GetFloat64(session *sessions.Session, key interface{}) (float64, bool) {
+	switch v := session.Values[key].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// GetBool returns the session value stored under key if it is a bool.
+func GetBool(session *sessions.Session, key interface{}) (bool, bool) {
+	v, ok := session.Values[key].(bool)
+	return v, ok
+}
+
+// GetBytes returns the session value stored under key if it is a []byte.
+func GetBytes(session *sessions.Session, key interface{}) ([]byte, bool) {
+	v, ok := session.Values[key].([]byte)
+	return v, ok
+}
+
+// GetTime returns the session value stored under key if it is a time.Time.
+func GetTime(session *sessions.Session, key interface{}) (time.Time, bool) {
+	v, ok := session.Values[key].(time.Time)
+	return v, ok
+}
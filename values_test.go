@@ -0,0 +1,63 @@
+package goredistore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestTypedGetters(t *testing.T) {
+	now := time.Now()
+	session := sessions.NewSession(nil, "session-key")
+	session.Values["string"] = "bar"
+	session.Values["int"] = 42
+	session.Values["int64"] = int64(7)
+	session.Values["json"] = 3.0 // how JSONSerializer decodes 3
+	session.Values["fraction"] = 3.5
+	session.Values["bool"] = true
+	session.Values["bytes"] = []byte("raw")
+	session.Values["time"] = now
+
+	if v, ok := GetString(session, "string"); !ok || v != "bar" {
+		t.Errorf("Expected bar; Got %v, %v", v, ok)
+	}
+	if _, ok := GetString(session, "int"); ok {
+		t.Errorf("Expected GetString to reject an int")
+	}
+	for key, want := range map[string]int{"int": 42, "int64": 7, "json": 3} {
+		if v, ok := GetInt(session, key); !ok || v != want {
+			t.Errorf("Expected %d for %s; Got %v, %v", want, key, v, ok)
+		}
+	}
+	for _, key := range []string{"fraction", "string", "missing"} {
+		if _, ok := GetInt(session, key); ok {
+			t.Errorf("Expected GetInt to reject %s", key)
+		}
+	}
+	if v, ok := GetFloat64(session, "int"); !ok || v != 42 {
+		t.Errorf("Expected 42; Got %v, %v", v, ok)
+	}
+	if _, ok := GetFloat64(session, "bool"); ok {
+		t.Errorf("Expected GetFloat64 to reject a bool")
+	}
+	if v, ok := GetBool(session, "bool"); !ok || !v {
+		t.Errorf("Expected true; Got %v, %v", v, ok)
+	}
+	if _, ok := GetBool(session, "string"); ok {
+		t.Errorf("Expected GetBool to reject a string")
+	}
+	if v, ok := GetBytes(session, "bytes"); !ok || !bytes.Equal(v, []byte("raw")) {
+		t.Errorf("Expected raw; Got %v, %v", v, ok)
+	}
+	if _, ok := GetBytes(session, "string"); ok {
+		t.Errorf("Expected GetBytes to reject a string")
+	}
+	if v, ok := GetTime(session, "time"); !ok || !v.Equal(now) {
+		t.Errorf("Expected %v; Got %v, %v", now, v, ok)
+	}
+	if _, ok := GetTime(session, "int"); ok {
+		t.Errorf("Expected GetTime to reject an int")
+	}
+}
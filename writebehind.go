@@ -0,0 +1,200 @@
+// Copyright 2019, Allen Woods.
+// All rights reserved.
+//
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package goredistore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// writeBehind buffers session writes in process and flushes them to redis
+// in batches.
+type writeBehind struct {
+	client    *redis.Client
+	maxBuffer int
+
+	mu      sync.Mutex
+	idle    *sync.Cond              // broadcast when a write finishes, see drop
+	pending map[string]pendingWrite // by redis key
+	writing map[string]int          // keys being written, by number of writes
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// pendingWrite is a buffered session value and the time it expires.
+type pendingWrite struct {
+	data     []byte
+	deadline time.Time
+}
+
+// EnableWriteBehind makes Save buffer session values in process and return
+// without waiting for redis. A background goroutine writes the buffered
+// sessions every interval in one pipeline, and Save writes the buffer itself
+// once it holds maxBuffer sessions (0 means no limit). Close flushes the
+// buffer. Loads from this store see buffered sessions; other processes only
+// see them once flushed.
+//
+// This trades durability for write throughput: sessions saved within the
+// last interval are lost if the process dies, and a failed background flush
+// is only retried on the next tick. Deleting a session drops its buffered
+// value, waiting for a flush of it in progress to finish so the session is
+// not written back. SaveMerge, SetTTL, KeepAlive, TTL and Exists write a
+// session's buffered value first, so they see it and a later flush does not
+// undo a TTL change. SCAN-based helpers such as Export only see flushed
+// sessions. Calling it again flushes the buffer and restarts with the new
+// settings. It returns
+// ErrInvalidInterval, leaving the store unchanged, if interval is not
+// positive.
+func (s *GoRediStore) EnableWriteBehind(interval time.Duration, maxBuffer int) error {
+	if interval <= 0 {
+		return ErrInvalidInterval
+	}
+	if s.writeBehind != nil {
+		s.writeBehind.close()
+	}
+	wb := &writeBehind{
+		client:    s.Client,
+		maxBuffer: maxBuffer,
+		pending:   make(map[string]pendingWrite),
+		writing:   make(map[string]int),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	wb.idle = sync.NewCond(&wb.mu)
+	s.writeBehind = wb
+	go wb.run(interval)
+	return nil
+}
+
+func (wb *writeBehind) run(interval time.Duration) {
+	defer close(wb.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wb.flush()
+		case <-wb.stop:
+			return
+		}
+	}
+}
+
+// buffer stores the value of key until the next flush, flushing right away
+// if the buffer is full.
+func (wb *writeBehind) buffer(key string, data []byte, ttl time.Duration) error {
+	wb.mu.Lock()
+	wb.pending[key] = pendingWrite{data: data, deadline: time.Now().Add(ttl)}
+	full := wb.maxBuffer > 0 && len(wb.pending) >= wb.maxBuffer
+	wb.mu.Unlock()
+	if full {
+		return wb.flush()
+	}
+	return nil
+}
+
+// get returns the buffered value of key, if any.
+func (wb *writeBehind) get(key string) ([]byte, bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	p, ok := wb.pending[key]
+	if !ok || !time.Now().Before(p.deadline) {
+		return nil, false
+	}
+	return p.data, true
+}
+
+// drop discards the buffered value of key before the session is deleted. A
+// write of key already taken from the buffer is waited for, so the caller's
+// DEL reaches redis after that write's SET and a deleted session is never
+// written back; a value put back by a failed write is discarded too.
+func (wb *writeBehind) drop(key string) {
+	wb.mu.Lock()
+	for wb.writing[key] > 0 {
+		wb.idle.Wait()
+	}
+	delete(wb.pending, key)
+	wb.mu.Unlock()
+}
+
+// flush writes every buffered value to redis with its remaining TTL. On
+// failure the values are put back, unless saved again in the meantime.
+func (wb *writeBehind) flush() error {
+	wb.mu.Lock()
+	batch := wb.pending
+	wb.pending = make(map[string]pendingWrite, len(batch))
+	wb.begin(batch)
+	wb.mu.Unlock()
+	return wb.write(context.Background(), batch)
+}
+
+// flushKey writes the buffered value of key, if any, to redis. A write of key
+// already taken from the buffer is waited for first, so the value is in
+// redis when flushKey returns and a later EXPIRE is not overwritten by it.
+func (wb *writeBehind) flushKey(ctx context.Context, key string) error {
+	wb.mu.Lock()
+	for wb.writing[key] > 0 {
+		wb.idle.Wait()
+	}
+	p, ok := wb.pending[key]
+	delete(wb.pending, key)
+	batch := map[string]pendingWrite{key: p}
+	if ok {
+		wb.begin(batch)
+	}
+	wb.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return wb.write(ctx, batch)
+}
+
+// begin marks the keys of batch as being written. It must be called with mu
+// held, in the same critical section that takes batch from the buffer.
+func (wb *writeBehind) begin(batch map[string]pendingWrite) {
+	for key := range batch {
+		wb.writing[key]++
+	}
+}
+
+// write writes batch, taken from the buffer with begin, to redis.
+func (wb *writeBehind) write(ctx context.Context, batch map[string]pendingWrite) error {
+	var err error
+	if len(batch) > 0 {
+		_, err = wb.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for key, p := range batch {
+				ttl := time.Until(p.deadline).Truncate(time.Millisecond)
+				if ttl > 0 {
+					pipe.Set(ctx, key, p.data, ttl)
+				}
+			}
+			return nil
+		})
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	for key, p := range batch {
+		if _, ok := wb.pending[key]; err != nil && !ok {
+			wb.pending[key] = p
+		}
+		if wb.writing[key]--; wb.writing[key] == 0 {
+			delete(wb.writing, key)
+		}
+	}
+	wb.idle.Broadcast()
+	return err
+}
+
+// close stops the background flusher and writes what is left in the buffer.
+func (wb *writeBehind) close() error {
+	close(wb.stop)
+	<-wb.done
+	return wb.flush()
+}
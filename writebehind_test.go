@@ -0,0 +1,200 @@
+package goredistore
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	if err = store.EnableWriteBehind(0, 0); err != ErrInvalidInterval || store.writeBehind != nil {
+		t.Errorf("Expected ErrInvalidInterval and no buffer for a zero interval; Got %v", err)
+	}
+	if err = store.EnableWriteBehind(50*time.Millisecond, 0); err != nil {
+		t.Fatalf("Error enabling write-behind: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp := NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+	if n, _ := store.Client.Exists(ctx, key).Result(); n != 0 {
+		t.Errorf("Expected the save to be buffered")
+	}
+
+	// The buffered session is readable from this store right away.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.HeaderMap["Set-Cookie"][0])
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected to load the buffered session; Got %v, %v", loaded.Values, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for n := int64(0); n == 0; n, _ = store.Client.Exists(ctx, key).Result() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the session to be flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ttl, _ := store.Client.PTTL(ctx, key).Result(); ttl <= 0 {
+		t.Errorf("Expected the flushed session to keep its TTL; Got %v", ttl)
+	}
+}
+
+func TestWriteBehindMaxBufferAndClose(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	store.EnableWriteBehind(time.Hour, 2)
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	defer client.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	var keys []string
+	for i := 0; i < 3; i++ {
+		session, _ := store.New(req, "session-key")
+		session.Values["i"] = i
+		if err = session.Save(req, NewRecorder()); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		keys = append(keys, store.key(session.ID))
+	}
+	defer client.Del(ctx, keys...)
+
+	// The second save filled the buffer and flushed it.
+	if n, _ := client.Exists(ctx, keys...).Result(); n != 2 {
+		t.Errorf("Expected 2 flushed sessions; Got %d", n)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatalf("Error closing store: %v", err)
+	}
+	if n, _ := client.Exists(ctx, keys...).Result(); n != 3 {
+		t.Errorf("Expected Close to flush the buffer; Got %d sessions", n)
+	}
+}
+
+// blockingPipelineHook holds the first pipeline until release is closed.
+type blockingPipelineHook struct {
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingPipelineHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *blockingPipelineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h *blockingPipelineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.once.Do(func() {
+			close(h.entered)
+			<-h.release
+		})
+		return next(ctx, cmds)
+	}
+}
+
+func TestWriteBehindLogoutDuringFlush(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: setup()})
+	store, err := NewGoRediStoreWithOptions(client, [][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.EnableWriteBehind(time.Hour, 0)
+	hook := &blockingPipelineHook{entered: make(chan struct{}), release: make(chan struct{})}
+	client.AddHook(hook)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- store.writeBehind.flush() }()
+	<-hook.entered
+
+	// Log out while the flush holding the session is in flight.
+	deleted := make(chan error, 1)
+	go func() {
+		session.Options.MaxAge = -1
+		deleted <- session.Save(req, NewRecorder())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(hook.release)
+	if err = <-flushed; err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if err = <-deleted; err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if n, _ := store.Client.Exists(ctx, key).Result(); n != 0 {
+		t.Error("Expected the deleted session not to be written back")
+	}
+}
+
+func TestWriteBehindKeepAlive(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewGoRediStore(10, "tcp", setup(), "", []byte("session-key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.EnableWriteBehind(time.Hour, 0)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	key := store.key(session.ID)
+	defer store.Client.Del(ctx, key)
+
+	if ok, err := store.Exists(ctx, session.ID); err != nil || !ok {
+		t.Errorf("Expected a buffered session to exist; Got %v, %v", ok, err)
+	}
+	kctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err = store.KeepAlive(kctx, session.ID, time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Expected KeepAlive to refresh a buffered session; Got %v", err)
+	}
+
+	// A later flush does not undo a TTL change.
+	session.Values["foo"] = "baz"
+	if err = session.Save(req, NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if err = store.SetTTL(ctx, session.ID, time.Minute); err != nil {
+		t.Fatalf("Error setting TTL: %v", err)
+	}
+	if err = store.writeBehind.flush(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if ttl, _ := store.TTL(ctx, session.ID); ttl > time.Minute {
+		t.Errorf("Expected the flush to keep the new TTL; Got %v", ttl)
+	}
+}